@@ -2,7 +2,7 @@ package main
 
 import (
 	"flag"
-	"fmt"
+	"log"
 	"github.com/atemmel/pok/pkg/constants"
 	"github.com/atemmel/pok/pkg/debug"
 	"github.com/atemmel/pok/pkg/pok"
@@ -12,39 +12,27 @@ import (
 
 var LogFileName string = "error.log"
 
-var isServing = false
 var disableAudio = false
 var disableOnline = false
 var fileToOpen string
+var entry int
+var slot int
 
 func init() {
 	debug.InitAssert(&LogFileName, false)
-	flag.BoolVar(&isServing, "serve", false, "Run as game server")
 	flag.BoolVar(&disableAudio, "disable-audio", false, "Toggle audio")
 	flag.BoolVar(&disableOnline, "disable-online", false, "Toggle online mode")
 	flag.BoolVar(&pok.DrawDebugInfo, "draw-debug-info", false, "Draw debug info")
+	flag.IntVar(&slot, "slot", 0, "Save slot to continue from and save to")
+	flag.IntVar(&entry, "entry", 0, "Entry to start at on the map given")
 
 	flag.Parse()
 
-	if !disableOnline {
-		if isServing {
-			server := pok.NewServer()
-			server.Serve()
-		}
-	}
+	// Skips the title screen, starting a new game on the map right away
 	fileToOpen = flag.Arg(0)
 }
 
 func main() {
-	if isServing {
-		return
-	}
-
-	if fileToOpen == "" {
-		fmt.Println("File to open not specified, lacks command line argument")
-		return
-	}
-
 	ebiten.SetWindowSize(constants.WindowSizeX, constants.WindowSizeY)
 	ebiten.SetWindowTitle("pok")
 	ebiten.SetWindowResizable(true)
@@ -52,28 +40,34 @@ func main() {
 	textures.Init()
 	game := pok.CreateGame()
 
-	game.Load(fileToOpen, 0)
-	defer game.Save()
+	game.Slot = slot
 	game.Audio = pok.NewAudio()
+	game.ApplyOptions()
 	if !disableAudio {
 		game.PlayAudio()
 	}
 
 	if !disableOnline {
+		game.Online = true
 		game.Client = pok.CreateClient()
-		connect := func() {
-			game.Player.Id = game.Client.Connect()
-			if game.Client.Active {
-				game.Player.Connected = true
-				go game.Client.ReadPlayer()
-			}
-		}
-
-		go connect()
 		defer game.Client.Disconnect()
 	}
 
-	if err := ebiten.RunGame(game); err != nil {
+	if fileToOpen != "" {
+		game.NewGame("", 0, fileToOpen, entry)
+	} else {
+		game.ShowTitle()
+	}
+
+	err := ebiten.RunGame(game)
+	// Closing the window can not be asked about, so what would be lost is
+	// saved instead
+	if game.Unsaved() {
+		if err := game.Save(); err != nil {
+			log.Println("Could not save:", err)
+		}
+	}
+	if err != nil && err != pok.ErrQuit {
 		panic(err)
 	}
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/atemmel/pok/pkg/recording"
+	"log"
+	"net"
+	"os"
+)
+
+const usage = `Usage: pokreplay [flags] <recording>
+
+Without -server or -listen, the recording is printed.
+
+Flags:`
+
+func printEntries(entries []recording.Entry) {
+	for _, entry := range entries {
+		if entry.Closed {
+			fmt.Printf("%12v\t%d\tclosed\n", entry.At, entry.Conn)
+			continue
+		}
+		transport := "tcp"
+		if entry.Udp {
+			transport = "udp"
+		}
+		fmt.Printf("%12v\t%d\t%v\t%s\t%T %+v\n", entry.At, entry.Conn, entry.Direction, transport, entry.Message, entry.Message)
+	}
+}
+
+func main() {
+	serverAddr := flag.String("server", "", "Replay the clients of the recording to the server at this address")
+	listenAddr := flag.String("listen", "", "Replay what the server sent over a connection to a client that connects to this address")
+	conn := flag.Uint("conn", 1, "Connection to replay with -listen")
+	speed := flag.Float64("speed", 1, "How much faster than the original to play")
+	password := flag.String("password", recording.DefaultPassword, "Password to log in with, since recordings do not hold them")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	entries, err := recording.ReadFile(flag.Arg(0))
+	if err != nil {
+		log.Fatalln("Could not read recording:", err)
+	}
+	opts := recording.Options{Speed: *speed, Password: *password}
+
+	switch {
+		case *serverAddr != "":
+			log.Println("Replaying", len(entries), "entries to", *serverAddr)
+			if err := recording.ReplayToServer(entries, *serverAddr, opts); err != nil {
+				log.Fatalln("Replay failed:", err)
+			}
+			log.Println("Replay done")
+		case *listenAddr != "":
+			listener, err := net.Listen("tcp", *listenAddr)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			log.Println("Waiting for a client on", listener.Addr(), "to replay connection", *conn, "to")
+			if err := recording.ReplayToClient(entries, uint32(*conn), listener, opts); err != nil {
+				log.Fatalln("Replay failed:", err)
+			}
+			listener.Close()
+			log.Println("Replay done")
+		default:
+			printEntries(entries)
+	}
+}
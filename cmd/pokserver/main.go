@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/atemmel/pok/pkg/server"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+const helpText = `Commands:
+  list                   list connected players
+  room [name]            list rooms, or pick the room that time and weather act on
+  kick <who> [reason]    disconnect a player, by id or name
+  broadcast <message>    show a message to every player
+  time [HH:MM]           show or change the time of day in the room
+  weather [map] [kind]   show or change the weather in the room, kind is clear, hail or rain
+  stop                   shut down the server
+  help                   show this text`
+
+// console is the state of the operator prompt
+type console struct {
+	s *server.Server
+	room string	// that time and weather commands act on, the default room if empty
+}
+
+type command struct {
+	name string
+	args []string
+	rest string	// everything after the name, untouched
+}
+
+func parseCommand(line string) command {
+	line = strings.TrimSpace(line)
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return command{}
+	}
+
+	rest := strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+	return command{strings.ToLower(fields[0]), fields[1:], rest}
+}
+
+func listPlayers(s *server.Server) {
+	players := s.Players()
+	if len(players) == 0 {
+		fmt.Println("No players connected")
+		return
+	}
+
+	sort.Slice(players, func(i, j int) bool {
+		return players[i].Id < players[j].Id
+	})
+
+	for _, p := range players {
+		status := ""
+		if !p.Connected {
+			status += " (awaiting reconnect)"
+		}
+		if p.Flagged {
+			status += " (flagged)"
+		}
+		fmt.Printf("%d\t%s\t%s\t%s\t%d,%d,%d%s\n", p.Id, p.Name, p.Room, p.Location, p.X, p.Y, p.Z, status)
+	}
+}
+
+func listRooms(s *server.Server) {
+	for _, r := range s.Rooms() {
+		fmt.Printf("%s\t%d/%d players\t%s\n", r.Name, r.Players, r.Capacity, r.Time)
+	}
+}
+
+func listWeather(s *server.Server, room string) {
+	weather, err := s.Weather(room)
+	if err != nil {
+		fmt.Println("Could not list weather:", err)
+		return
+	}
+	if len(weather) == 0 {
+		fmt.Println("Every map has its default weather")
+		return
+	}
+
+	locations := make([]string, 0, len(weather))
+	for location := range weather {
+		locations = append(locations, location)
+	}
+	sort.Strings(locations)
+
+	for _, location := range locations {
+		fmt.Printf("%s\t%s\n", location, server.WeatherName(weather[location]))
+	}
+}
+
+// runCommand executes a single operator command, returning false once the
+// server should stop
+func (c *console) runCommand(cmd command) bool {
+	s := c.s
+	switch cmd.name {
+		case "":
+		case "list", "ls", "players":
+			listPlayers(s)
+		case "room", "rooms":
+			if len(cmd.args) == 0 {
+				listRooms(s)
+				break
+			}
+			if _, _, err := s.Time(cmd.args[0]); err != nil {
+				fmt.Println("No room called", cmd.args[0])
+				break
+			}
+			c.room = cmd.args[0]
+			fmt.Println("Time and weather now act on room", c.room)
+		case "kick":
+			if len(cmd.args) == 0 {
+				fmt.Println("Usage: kick <who> [reason]")
+				break
+			}
+			id, ok := s.FindPlayer(cmd.args[0])
+			if !ok {
+				fmt.Println("No player called", cmd.args[0])
+				break
+			}
+			reason := strings.TrimSpace(strings.TrimPrefix(cmd.rest, cmd.args[0]))
+			if reason == "" {
+				reason = "Kicked by operator"
+			}
+			if !s.Kick(id, reason) {
+				fmt.Println("No player with id", id)
+			}
+		case "broadcast", "say":
+			if cmd.rest == "" {
+				fmt.Println("Usage: broadcast <message>")
+				break
+			}
+			s.Announce(cmd.rest)
+		case "time":
+			if len(cmd.args) == 0 {
+				hour, minute, err := s.Time(c.room)
+				if err != nil {
+					fmt.Println("Could not tell the time:", err)
+					break
+				}
+				fmt.Println("It is", server.FormatClock(hour, minute))
+				break
+			}
+			hour, minute, ok := server.ParseClock(cmd.args[0])
+			if !ok {
+				fmt.Println("Usage: time [HH:MM]")
+				break
+			}
+			if err := s.SetTime(c.room, hour, minute); err != nil {
+				fmt.Println("Could not change the time:", err)
+			}
+		case "weather":
+			if len(cmd.args) == 0 {
+				listWeather(s, c.room)
+				break
+			}
+			if len(cmd.args) != 2 {
+				fmt.Println("Usage: weather <map> <clear|hail|rain>")
+				break
+			}
+			weather, ok := server.ParseWeather(cmd.args[1])
+			if !ok {
+				fmt.Println("Unknown weather:", cmd.args[1])
+				break
+			}
+			if err := s.SetWeather(c.room, cmd.args[0], weather); err != nil {
+				fmt.Println("Could not change weather:", err)
+			}
+		case "stop", "quit", "exit":
+			return false
+		case "help":
+			fmt.Println(helpText)
+		default:
+			fmt.Println("Unknown command:", cmd.name)
+			fmt.Println(helpText)
+	}
+	return true
+}
+
+func readCommands(s *server.Server) {
+	c := console{s, ""}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if !c.runCommand(parseCommand(scanner.Text())) {
+			s.Shutdown("Server is shutting down")
+			return
+		}
+	}
+	// Stdin was closed, as when running in the background, so the server
+	// keeps going without commands
+}
+
+func main() {
+	configPath := flag.String("config", server.DefaultConfigPath, "Path to server config")
+	addr := flag.String("addr", "", "Address to listen on, overrides config")
+	port := flag.String("port", "", "Port to listen on, overrides config")
+	webSocketPort := flag.String("websocket-port", "", "Port to accept websockets on, overrides config")
+	udpPort := flag.String("udp-port", "", "Port to accept player states over udp on, overrides config")
+	adminAddr := flag.String("admin-addr", "", "Address to serve status and admin requests on, overrides config")
+	record := flag.String("record", "", "File to record all traffic to, overrides config")
+	maxConnections := flag.Int("max-connections", 0, "Maximum number of players per room, overrides config")
+	noStdin := flag.Bool("no-stdin", false, "Do not read operator commands from stdin")
+	flag.Parse()
+
+	conf, err := server.ReadConfig(*configPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Fatalln("Could not read config:", err)
+		}
+		log.Println("No config found at", *configPath, "using defaults")
+	}
+
+	if *addr != "" {
+		conf.Url = *addr
+	}
+	if *port != "" {
+		conf.Port = *port
+	}
+	if *webSocketPort != "" {
+		conf.WebSocketPort = *webSocketPort
+	}
+	if *udpPort != "" {
+		conf.UdpPort = *udpPort
+	}
+	if *adminAddr != "" {
+		conf.AdminAddr = *adminAddr
+	}
+	if *record != "" {
+		conf.RecordFile = *record
+	}
+	if *maxConnections > 0 {
+		conf.MaxConnections = *maxConnections
+	}
+
+	s, err := server.NewServer(conf)
+	if err != nil {
+		log.Fatalln("Could not load accounts or players:", err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Println("Recieved", sig)
+		s.Shutdown("Server is shutting down")
+	}()
+
+	if !*noStdin {
+		go readCommands(s)
+	}
+
+	if err := s.Serve(); err != nil {
+		log.Fatalln(err)
+	}
+}
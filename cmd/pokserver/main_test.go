@@ -0,0 +1,28 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	type parseCommandTest struct {
+		In string
+		Want command
+	}
+
+	tests := []parseCommandTest{
+		{"", command{}},
+		{"   ", command{}},
+		{"list", command{"list", []string{}, ""}},
+		{"KICK 3", command{"kick", []string{"3"}, "3"}},
+		{"kick 3  being  rude ", command{"kick", []string{"3", "being", "rude"}, "3  being  rude"}},
+		{"say  hello   there", command{"say", []string{"hello", "there"}, "hello   there"}},
+	}
+
+	for _, test := range tests {
+		if output := parseCommand(test.In); !reflect.DeepEqual(output, test.Want) {
+			t.Errorf("Output %+v not equal to %+v", output, test.Want)
+		}
+	}
+}
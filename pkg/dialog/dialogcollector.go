@@ -10,6 +10,7 @@ type DialogTreeCollectorResult struct {
 	Dialog string
 	NodeId NodeId
 	Opt string
+	Choices []string
 }
 
 func MakeDialogTreeCollector(tree *DialogTree) DialogTreeCollector {
@@ -56,6 +57,22 @@ func (coll *DialogTreeCollector) VisitBinary(b *BinaryDialogNode) {
 }
 
 func (coll *DialogTreeCollector) VisitChoice(c *ChoiceDialogNode) {
+	coll.nextResult.Dialog = c.Dialog
+	coll.nextResult.NodeId = c.GetNodeId()
+	coll.nextResult.Choices = c.Choices
+}
+
+// Choose follows one of the results of the current choice node, which is
+// where the collector stays until a choice is made
+func (coll *DialogTreeCollector) Choose(index int) {
+	if coll.current == nil {
+		return
+	}
+	choice, ok := (*coll.tree)[*coll.current].(*ChoiceDialogNode)
+	if !ok || index < 0 || index >= len(choice.Results) {
+		return
+	}
+	coll.current = choice.Results[index]
 }
 
 func (coll *DialogTreeCollector) VisitEffect(e *EffectDialogNode) {
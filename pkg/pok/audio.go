@@ -38,6 +38,17 @@ func (a *Audio) PlayPlayerJump() {
 	a.playerJumpPlayer.Play()
 }
 
+// SetVolume changes the volume of everything, from 0 to 1
+func (a *Audio) SetVolume(v float64) {
+	if a.audioContext == nil {
+		return
+	}
+	a.audioPlayer.SetVolume(v)
+	a.thudPlayer.SetVolume(v)
+	a.doorPlayer.SetVolume(v)
+	a.playerJumpPlayer.SetVolume(v)
+}
+
 func NewAudio() Audio {
 	ctx := audio.NewContext(44100)
 	/*
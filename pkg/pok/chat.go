@@ -0,0 +1,174 @@
+package pok
+
+import (
+	"fmt"
+	"github.com/atemmel/pok/pkg/constants"
+	"github.com/atemmel/pok/pkg/debug"
+	"github.com/atemmel/pok/pkg/fonts"
+	"github.com/atemmel/pok/pkg/protocol"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/text"
+	"golang.org/x/image/font"
+	"image/color"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// The server cuts longer messages short anyway
+	maxChatInput = 120
+	nChatLines = 8
+	// Lines disappear after this long, unless the chat is open
+	chatLineLifetime = 10 * time.Second
+	chatLineHeight = 12
+	chatMarginX = 4
+	chatWidth = constants.DisplaySizeX - chatMarginX * 2
+)
+
+var (
+	mapChatClr = color.RGBA{248, 248, 248, 255}
+	globalChatClr = color.RGBA{248, 224, 120, 255}
+	systemChatClr = color.RGBA{248, 136, 120, 255}
+	chatShadowClr = color.RGBA{40, 40, 48, 255}
+	chatBgClr = color.RGBA{0, 0, 0, 128}
+)
+
+type chatLine struct {
+	str string
+	clr color.Color
+	at time.Time
+}
+
+// ChatBox keeps track of the recent chat history, and of what the player is
+// currently typing
+type ChatBox struct {
+	Input Typewriter
+	lines []chatLine
+	font font.Face
+	bg *ebiten.Image
+}
+
+func NewChatBox() ChatBox {
+	face, err := fonts.LoadFont(constants.FontsDir + "pokemon_pixel_font.ttf", 16)
+	debug.Assert(err)
+
+	bg := ebiten.NewImage(constants.DisplaySizeX, (nChatLines + 1) * chatLineHeight + 4)
+	bg.Fill(chatBgClr)
+
+	return ChatBox{
+		font: face,
+		bg: bg,
+	}
+}
+
+// Open lets the player start typing a message to the given scope
+func (c *ChatBox) Open(g *Game, scope protocol.ChatScope) {
+	query := "Say: "
+	if scope == protocol.GlobalChat {
+		query = "Shout: "
+	}
+
+	c.Input.Start(query, func(str string) {
+		if str == "" {
+			return
+		}
+		if err := g.Client.SendChat(scope, str); err != nil {
+			c.push("Could not send message", systemChatClr)
+		}
+	})
+}
+
+func (c *ChatBox) HandleInputs() {
+	c.Input.HandleInputs()
+	for utf8.RuneCountInString(c.Input.Input) > maxChatInput {
+		_, size := utf8.DecodeLastRuneInString(c.Input.Input)
+		c.Input.Input = c.Input.Input[:len(c.Input.Input) - size]
+	}
+}
+
+// Update moves newly recieved messages into the history
+func (c *ChatBox) Update(g *Game) {
+	for {
+		chat, ok := g.Client.PopChat()
+		if !ok {
+			break
+		}
+
+		switch chat.Scope {
+			case protocol.MapChat:
+				c.push(fmt.Sprintf("%s: %s", g.Client.Name(chat.Id), chat.Text), mapChatClr)
+			case protocol.GlobalChat:
+				c.push(fmt.Sprintf("[All] %s: %s", g.Client.Name(chat.Id), chat.Text), globalChatClr)
+			case protocol.SystemChat:
+				c.push(chat.Text, systemChatClr)
+		}
+	}
+}
+
+func (c *ChatBox) push(str string, clr color.Color) {
+	now := time.Now()
+	for _, line := range c.wrap(str) {
+		c.lines = append(c.lines, chatLine{line, clr, now})
+	}
+	if len(c.lines) > nChatLines {
+		c.lines = c.lines[len(c.lines) - nChatLines:]
+	}
+}
+
+// wrap splits str into lines that fit within chatWidth, breaking at spaces
+// where possible
+func (c *ChatBox) wrap(str string) []string {
+	lines := []string{}
+	for font.MeasureString(c.font, str).Ceil() > chatWidth {
+		fit := len(str)
+		for fit > 0 && font.MeasureString(c.font, str[:fit]).Ceil() > chatWidth {
+			_, size := utf8.DecodeLastRuneInString(str[:fit])
+			fit -= size
+		}
+
+		cut := fit
+		for i := fit; i > 0; i-- {
+			if str[i - 1] == ' ' {
+				cut = i
+				break
+			}
+		}
+		if cut == 0 {
+			break
+		}
+
+		lines = append(lines, strings.TrimRight(str[:cut], " "))
+		str = str[cut:]
+	}
+	return append(lines, str)
+}
+
+func (c *ChatBox) drawString(target *ebiten.Image, str string, y int, clr color.Color) {
+	text.Draw(target, str, c.font, chatMarginX + 1, y + 1, chatShadowClr)
+	text.Draw(target, str, c.font, chatMarginX, y, clr)
+}
+
+// Draw shows the chat in the bottom left corner, above where the dialog box
+// goes. Old lines are only shown while the player is typing.
+func (c *ChatBox) Draw(target *ebiten.Image) {
+	now := time.Now()
+	bottom := constants.DisplaySizeY - chatLineHeight * 5
+
+	if c.Input.Active {
+		opt := &ebiten.DrawImageOptions{}
+		opt.GeoM.Translate(0, float64(bottom - c.bg.Bounds().Dy() + 4))
+		target.DrawImage(c.bg, opt)
+		c.drawString(target, c.Input.GetDisplayString() + "_", bottom, mapChatClr)
+	}
+
+	y := bottom - chatLineHeight
+	for i := len(c.lines) - 1; i >= 0; i-- {
+		line := &c.lines[i]
+		if !c.Input.Active && now.Sub(line.at) > chatLineLifetime {
+			break
+		}
+		c.drawString(target, line.str, y, line.clr)
+		y -= chatLineHeight
+	}
+}
@@ -2,125 +2,778 @@ package pok
 
 import (
 	"bufio"
-	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/atemmel/pok/pkg/protocol"
+	"github.com/atemmel/pok/pkg/websocket"
 	"log"
 	"net"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-const DataLength = 4096
+type ConnectionState int32
+
+const (
+	Offline ConnectionState = iota
+	Connecting
+	Connected
+	Reconnecting
+)
+
+const (
+	minReconnectDelay = 500 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+	dialTimeout = 10 * time.Second
+)
+
+type rejectedError struct {
+	reason string
+}
+
+func (e *rejectedError) Error() string {
+	return "rejected by server: " + e.reason
+}
 
 type Client struct {
 	conf ClientConfig
 	rw *bufio.ReadWriter
 	conn net.Conn
+	udp net.Conn	// nil unless player states go over udp
+	connMutex sync.Mutex	// guards rw, conn, udp, udpKey, udpSeq, id, name, resync and attempt
 	playerMap PlayerMap
 
-	Active bool
+	id int
+	name string	// as the server spells it
+	token string	// for resuming the session after a reconnect
+	attempt uint32	// bumped by Disconnect, to cancel connecting
+	state int32	// a ConnectionState, only accessed atomically
+	resync bool	// set when the server needs our full state again
+	udpKey uint64	// given by the server, sent with every datagram
+	udpSeq uint32	// of the last datagram sent
+
+	// Upload bookkeeping, see SyncPlayer
+	lastState protocol.PlayerState
+	lastSend time.Time
+	lastHeartbeat time.Time	// or anything else sent over tcp
+	hasSent bool
+
+	notices []string	// server messages waiting to be shown
+	chat []protocol.Chat	// chat messages waiting to be shown
+	worldChanges []worldChange	// waiting to be applied to the map
+	correction *protocol.Correction	// the latest, if not yet applied
+	spawn *protocol.Spawn	// where we left off last time, if not yet applied
+	requests []protocol.Request	// waiting to be shown
+	noticeMutex sync.Mutex	// guards notices, chat, worldChanges, correction, spawn and requests
+}
+
+// worldChange is either something another player just did, everything
+// that has been done to a map before we entered it, or new weather
+type worldChange struct {
+	event *protocol.WorldEvent
+	state *protocol.WorldState
+	weather *protocol.Weather
 }
 
 type PlayerMap struct {
-	players map[int]Player
+	players map[int]*remotePlayer
+	names map[int]string	// of everyone on the server, not just nearby players
 	mutex sync.Mutex
 }
 
+type remotePlayer struct {
+	Player Player
+	snapshots snapshotBuffer
+	seq uint32	// of the newest state that arrived over udp
+}
+
 func CreateClient() Client {
 	return Client{
-		ClientConfig{},
-		nil,
-		nil,
-		PlayerMap{
-			make(map[int]Player),
+		playerMap: PlayerMap{
+			make(map[int]*remotePlayer),
+			make(map[int]string),
 			sync.Mutex{},
 		},
-		false,
+		id: -1,
 	}
 }
 
-func (c *Client) Connect() int {
-	log.Println("Attempting to connect to server...")
-	var err error
+func (c *Client) State() ConnectionState {
+	return ConnectionState(atomic.LoadInt32(&c.state))
+}
+
+func (c *Client) setState(state ConnectionState) {
+	atomic.StoreInt32(&c.state, int32(state))
+}
 
-	c.conf, err = ReadClientConfig()
+func (c *Client) Active() bool {
+	return c.State() == Connected
+}
+
+func (c *Client) Id() int {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+	return c.id
+}
+
+// Attempt identifies the attempts at connecting that may still be made.
+// Disconnect cancels them, including one that has not started yet.
+func (c *Client) Attempt() uint32 {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+	return c.attempt
+}
+
+// Connect makes the first attempt at connecting as a player, using the
+// config in ConfigDir, unless Disconnect has been called since the attempt
+// was taken. If it fails, ReadPlayer will keep trying in the background.
+func (c *Client) Connect(name, password string, attempt uint32) int {
+	conf, err := ReadClientConfig()
 	if err != nil {
 		log.Println("Could not read client config")
+		c.setState(Offline)
 		return -1
 	}
+	conf.Name = name
+	conf.Password = password
+	c.forget()
+	return c.connect(conf, attempt)
+}
+
+// ConnectWith is Connect with a config that does not come from a file
+func (c *Client) ConnectWith(conf ClientConfig) int {
+	return c.connect(conf, c.Attempt())
+}
 
-	c.conn, err = net.Dial("tcp", c.conf.ServerUrl + ":" + c.conf.ServerPort)
+func (c *Client) connect(conf ClientConfig, attempt uint32) int {
+	c.connMutex.Lock()
+	if c.attempt != attempt {
+		c.connMutex.Unlock()
+		return -1
+	}
+	// From here on, Disconnect sets the state to Offline, which is noticed
+	// below
+	c.setState(Connecting)
+	c.connMutex.Unlock()
+
+	log.Println("Attempting to connect to server...")
+	conf.setDefaults()
+	c.conf = conf
+
+	err := c.dial()
 	if err != nil {
 		log.Println("Connection failed")
 		log.Println(err)
+		if rejection, rejected := err.(*rejectedError); rejected {
+			c.pushNotice("Could not join the server: " + rejection.reason)
+			c.setState(Offline)
+		} else {
+			atomic.CompareAndSwapInt32(&c.state, int32(Connecting), int32(Reconnecting))
+		}
+		return -1
+	}
+
+	if !atomic.CompareAndSwapInt32(&c.state, int32(Connecting), int32(Connected)) {
+		// Disconnect was called while dialing
+		c.hangUp()
 		return -1
 	}
 
 	log.Println("Connection succeeded!")
-	c.rw = bufio.NewReadWriter(
-		bufio.NewReader(c.conn),
-		bufio.NewWriter(c.conn),
+	return c.Id()
+}
+
+// hangUp closes a connection that was opened after Disconnect was called
+func (c *Client) hangUp() {
+	c.connMutex.Lock()
+	c.conn.Close()
+	if c.udp != nil {
+		c.udp.Close()
+		c.udp = nil
+	}
+	c.connMutex.Unlock()
+}
+
+// forget clears everything left from an earlier session, so that it is
+// not resumed or shown in the next one
+func (c *Client) forget() {
+	c.connMutex.Lock()
+	c.id = -1
+	c.name = ""
+	c.token = ""
+	c.connMutex.Unlock()
+	c.hasSent = false
+	c.clearPlayers()
+
+	c.noticeMutex.Lock()
+	c.notices = nil
+	c.chat = nil
+	c.worldChanges = nil
+	c.correction = nil
+	c.spawn = nil
+	c.requests = nil
+	c.noticeMutex.Unlock()
+}
+
+// dial opens a new connection and performs the handshake, presenting the
+// token from the previous session if there is one
+func (c *Client) dial() error {
+	conn, err := c.open()
+	if err != nil {
+		return err
+	}
+
+	rw := bufio.NewReadWriter(
+		bufio.NewReader(conn),
+		bufio.NewWriter(conn),
 	)
-	data, err := c.rw.ReadBytes('\n')
+
+	welcome, err := handshake(rw, &protocol.Hello{
+		Version: protocol.Version,
+		Token: c.token,
+		Name: c.conf.Name,
+		Password: c.conf.Password,
+		Room: c.conf.Room,
+		Spawn: c.conf.Spawn,
+	})
 	if err != nil {
-		log.Println("Could not be given an id")
-		return -1
+		conn.Close()
+		return err
+	}
+
+	var udp net.Conn
+	if c.conf.Udp && welcome.UdpPort != 0 {
+		udp, err = c.conf.Network.DialPacket(net.JoinHostPort(c.conf.ServerUrl, strconv.Itoa(welcome.UdpPort)))
+		if err != nil {
+			log.Println("Could not open udp channel, sending everything over tcp:", err)
+			udp = nil
+		}
+	}
+
+	// Queued before the connection is handed over, so that the player is
+	// moved before it is synced
+	if welcome.Spawn.Location != "" {
+		c.noticeMutex.Lock()
+		c.spawn = &welcome.Spawn
+		c.noticeMutex.Unlock()
+	}
+
+	c.connMutex.Lock()
+	if c.token != "" && welcome.Id != c.id {
+		log.Println("Session could not be resumed, given new id", welcome.Id)
+	}
+	if c.udp != nil {
+		c.udp.Close()
+	}
+	c.conn = conn
+	c.rw = rw
+	c.udp = udp
+	c.udpKey = welcome.UdpKey
+	c.id = welcome.Id
+	c.name = welcome.Name
+	c.token = welcome.Token
+	c.resync = true
+	c.connMutex.Unlock()
+
+	if udp != nil {
+		go c.readDatagrams(udp, welcome.UdpKey)
+		// Let the server know where to send states
+		c.writeDatagram(&protocol.Heartbeat{})
+	}
+
+	// The server introduces everyone anew
+	c.clearPlayers()
+	return nil
+}
+
+// open connects to the server over the transport given in the config
+func (c *Client) open() (net.Conn, error) {
+	addr := net.JoinHostPort(c.conf.ServerUrl, c.conf.ServerPort)
+	switch c.conf.Transport {
+		case TcpTransport:
+			return c.conf.Network.Dial(addr)
+		case WebSocketTransport, SecureWebSocketTransport:
+			// May have to go through a proxy, so always over the real network
+			conn, err := websocket.Dial(c.conf.Transport + "://" + addr + c.conf.WebSocketPath, dialTimeout)
+			if err != nil {
+				return nil, err
+			}
+			return conn, nil
+	}
+	return nil, fmt.Errorf("unknown transport %q", c.conf.Transport)
+}
+
+func handshake(rw *bufio.ReadWriter, hello *protocol.Hello) (*protocol.Welcome, error) {
+	err := protocol.WriteMessage(rw, hello)
+	if err == nil {
+		err = rw.Flush()
 	}
-	data = data[:len(data) - 1]	// Discard newline byte
-	id, err := strconv.Atoi(string(data))
 	if err != nil {
-		log.Println("Id given (", string(data), ") was not valid")
-		return -1
+		return nil, err
+	}
+
+	msg, err := protocol.ReadMessage(rw)
+	if err != nil {
+		return nil, err
 	}
-	c.Active = true
-	return id
+
+	switch m := msg.(type) {
+		case *protocol.Welcome:
+			return m, nil
+		case *protocol.Reject:
+			return nil, &rejectedError{m.Reason}
+	}
+	return nil, errors.New("unexpected reply to hello")
 }
 
-func (c *Client) WritePlayer(player *Player) {
-	data, _ := json.Marshal(player)
-	data = append(data, '\n')
-	c.rw.Write(data)
-	c.rw.Flush()
+func (c *Client) write(msg protocol.Message) error {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+
+	if c.rw == nil {
+		return errors.New("not connected")
+	}
+
+	err := protocol.WriteMessage(c.rw, msg)
+	if err != nil {
+		return err
+	}
+	return c.rw.Flush()
 }
 
-func (c *Client) ReadPlayer() {
+// writeDatagram sends a message over udp, returning false if there is no
+// udp channel to send it over
+func (c *Client) writeDatagram(msg protocol.Message) bool {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+
+	if c.udp == nil {
+		return false
+	}
+
+	c.udpSeq++
+	datagram, err := protocol.EncodeDatagram(c.udpKey, c.udpSeq, msg)
+	if err != nil {
+		return false
+	}
+	c.udp.Write(datagram)
+	return true
+}
+
+// readDatagrams handles player states that arrive over udp, until the udp
+// channel is closed or replaced
+func (c *Client) readDatagrams(udp net.Conn, key uint64) {
+	buf := make([]byte, protocol.MaxDatagramSize)
 	for {
-		bytes, err := c.rw.ReadBytes('\n')
+		n, err := udp.Read(buf)
 		if err != nil {
-			//TODO Identify which errors should be ignored and which 
-			//     errors should abort the connection
+			c.connMutex.Lock()
+			replaced := c.udp != udp
+			c.connMutex.Unlock()
+			if replaced {
+				return
+			}
+			// Most likely an unreachable port reported for an earlier write
+			continue
+		}
+
+		datagramKey, seq, msg, err := protocol.DecodeDatagram(buf[:n])
+		if err != nil || datagramKey != key {
+			continue
+		}
+
+		if state, ok := msg.(*protocol.PlayerState); ok {
+			c.updatePlayer(state, seq)
+		}
+	}
+}
+
+// SyncPlayer is meant to be called every frame. It uploads the player state
+// at most TickRate times per second, and only if the state has changed since
+// it was last sent. States go over udp if possible, except for the first one
+// after connecting. A heartbeat is sent over tcp if nothing has been sent
+// there for HeartbeatInterval milliseconds. The player id is also kept up to
+// date, as it may change if the session could not be resumed after a
+// reconnect.
+func (c *Client) SyncPlayer(player *Player) {
+	c.connMutex.Lock()
+	player.Id = c.id
+	player.Name = c.name
+	if c.resync {
+		c.hasSent = false
+		c.resync = false
+	}
+	c.connMutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastSend) >= time.Second / time.Duration(c.conf.TickRate) {
+		state := player.State()
+		if !c.hasSent || state != c.lastState {
+			// The first state after connecting must not get lost
+			if !c.hasSent || !c.writeDatagram(&state) {
+				c.write(&state)
+				c.lastHeartbeat = now
+			}
+			c.lastState = state
+			c.lastSend = now
+			c.hasSent = true
+		}
+	}
+
+	if now.Sub(c.lastHeartbeat) >= time.Duration(c.conf.HeartbeatInterval) * time.Millisecond {
+		c.write(&protocol.Heartbeat{})
+		// Also keeps the way open through routers, for states from others
+		c.writeDatagram(&protocol.Heartbeat{})
+		c.lastHeartbeat = now
+	}
+}
+
+// ReadPlayer handles incoming messages until Disconnect is called, and
+// reconnects whenever the connection is lost
+func (c *Client) ReadPlayer() {
+	for {
+		switch c.State() {
+			case Offline:
+				return
+			case Connected:
+				c.readMessages()
+			default:
+				c.reconnect()
+		}
+	}
+}
+
+func (c *Client) readMessages() {
+	for {
+		msg, err := protocol.ReadMessage(c.rw)
+		if errors.Is(err, protocol.ErrMalformed) {
+			log.Println("Ill-formed message recieved:", err)
+			continue
+		} else if err != nil {
+			if c.State() == Offline {
+				return
+			}
 			log.Println("Could not read message:", err)
-			c.Active = false
+			c.connMutex.Lock()
+			c.conn.Close()
+			c.connMutex.Unlock()
+			c.setState(Reconnecting)
 			return
-		} else {
-			player := Player{}
-			err := json.Unmarshal(bytes, &player)
-			if err == nil {
-				c.updatePlayer(&player)
-			} else {
-				log.Println(err)
-				log.Println("Recieved:", string(bytes))
+		}
+
+		switch m := msg.(type) {
+			case *protocol.PlayerState:
+				c.updatePlayer(m, 0)
+			case *protocol.Join:
+				log.Println(m.Name, "connected")
+				c.setName(m.Id, m.Name)
+			case *protocol.Leave:
+				log.Println(c.Name(m.Id), "disconnected")
+				c.removePlayer(m.Id)
+				c.playerMap.mutex.Lock()
+				delete(c.playerMap.names, m.Id)
+				c.playerMap.mutex.Unlock()
+			case *protocol.EnterMap:
+				log.Println("Player", m.Id, "entered", m.Location)
+			case *protocol.LeaveMap:
+				log.Println("Player", m.Id, "left", m.Location)
+				c.removePlayer(m.Id)
+			case *protocol.Announcement:
+				log.Println("Server:", m.Text)
+				c.pushNotice(m.Text)
+			case *protocol.Chat:
+				c.pushChat(*m)
+			case *protocol.WorldEvent:
+				c.pushWorldChange(worldChange{event: m})
+			case *protocol.WorldState:
+				c.pushWorldChange(worldChange{state: m})
+			case *protocol.Clock:
+				worldClock.Sync(m.Seconds, m.Rate, time.Now())
+			case *protocol.Weather:
+				c.pushWorldChange(worldChange{weather: m})
+			case *protocol.Correction:
+				log.Println("Move refused by server, moving back to", m.Location, m.X, m.Y, m.Z)
+				c.noticeMutex.Lock()
+				c.correction = m
+				c.noticeMutex.Unlock()
+			case *protocol.Request:
+				c.noticeMutex.Lock()
+				c.requests = append(c.requests, *m)
+				c.noticeMutex.Unlock()
+			case *protocol.Kick:
+				log.Println("Kicked from server:", m.Reason)
+				c.pushNotice("You were kicked: " + m.Reason)
+				c.Disconnect()
+				return
+			default:
+				log.Println("Unexpected message of kind", msg.Kind())
+		}
+	}
+}
+
+// reconnect retries with exponential backoff until it succeeds, the server
+// turns us away, or Disconnect is called
+func (c *Client) reconnect() {
+	delay := minReconnectDelay
+	for {
+		time.Sleep(delay)
+		if c.State() == Offline {
+			return
+		}
+
+		log.Println("Attempting to reconnect...")
+		err := c.dial()
+		if err == nil {
+			if !atomic.CompareAndSwapInt32(&c.state, int32(Reconnecting), int32(Connected)) {
+				// Disconnect was called while dialing
+				c.hangUp()
+				return
 			}
+			log.Println("Reconnected with id", c.Id())
+			return
+		}
+
+		log.Println("Reconnect failed:", err)
+		if rejection, rejected := err.(*rejectedError); rejected {
+			c.pushNotice("Could not rejoin the server: " + rejection.reason)
+			c.setState(Offline)
+			return
+		}
+
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
 		}
 	}
 }
 
-func (c *Client) updatePlayer(player *Player) {
+// updatePlayer queues a state for interpolation. States that arrive over
+// udp have a sequence number, and are dropped if a newer one has already
+// arrived. States from tcp have a sequence number of 0.
+func (c *Client) updatePlayer(state *protocol.PlayerState, seq uint32) {
 	c.playerMap.mutex.Lock()
-	if !player.Connected {	// He disconnected
-		delete(c.playerMap.players, player.Id)
-		log.Println("Player", player.Id, "disconnected")
-	} else {
-		c.playerMap.players[player.Id] = *player
+	defer c.playerMap.mutex.Unlock()
+
+	remote, ok := c.playerMap.players[state.Id]
+	if !ok {
+		remote = &remotePlayer{}
+		remote.Player.Name = c.playerMap.names[state.Id]
+		c.playerMap.players[state.Id] = remote
 	}
+
+	if seq != 0 {
+		if !protocol.Newer(seq, remote.seq) {
+			return
+		}
+		remote.seq = seq
+	}
+	remote.snapshots.Push(time.Now(), *state)
+}
+
+// InterpolatePlayers moves every remote player to where it should be drawn
+func (c *Client) InterpolatePlayers(now time.Time) {
+	c.playerMap.mutex.Lock()
+	for _, remote := range c.playerMap.players {
+		if state, ok := remote.snapshots.Sample(now); ok {
+			remote.Player.ApplyState(&state)
+		}
+	}
+	c.playerMap.mutex.Unlock()
+}
+
+func (c *Client) removePlayer(id int) {
+	c.playerMap.mutex.Lock()
+	delete(c.playerMap.players, id)
 	c.playerMap.mutex.Unlock()
 }
 
+func (c *Client) clearPlayers() {
+	c.playerMap.mutex.Lock()
+	c.playerMap.players = make(map[int]*remotePlayer)
+	c.playerMap.names = make(map[int]string)
+	c.playerMap.mutex.Unlock()
+}
+
+func (c *Client) setName(id int, name string) {
+	c.playerMap.mutex.Lock()
+	c.playerMap.names[id] = name
+	if remote, ok := c.playerMap.players[id]; ok {
+		remote.Player.Name = name
+	}
+	c.playerMap.mutex.Unlock()
+}
+
+// PlayerAt returns the id of the remote player standing on a tile of our
+// map, if there is one
+func (c *Client) PlayerAt(location string, x, y, z int) (int, bool) {
+	c.playerMap.mutex.Lock()
+	defer c.playerMap.mutex.Unlock()
+
+	for id, remote := range c.playerMap.players {
+		p := &remote.Player
+		if p.Location == location && p.Char.X == x && p.Char.Y == y && p.Char.Z == z {
+			return id, true
+		}
+	}
+	return -1, false
+}
+
+// Name returns the name of any player on the server, including our own
+func (c *Client) Name(id int) string {
+	c.connMutex.Lock()
+	if id == c.id {
+		defer c.connMutex.Unlock()
+		return c.name
+	}
+	c.connMutex.Unlock()
+
+	c.playerMap.mutex.Lock()
+	defer c.playerMap.mutex.Unlock()
+	if name, ok := c.playerMap.names[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("Player %d", id)
+}
+
+func (c *Client) pushNotice(text string) {
+	c.noticeMutex.Lock()
+	c.notices = append(c.notices, text)
+	c.noticeMutex.Unlock()
+}
+
+// PopNotice returns the oldest message from the server that has not been
+// shown yet
+func (c *Client) PopNotice() (string, bool) {
+	c.noticeMutex.Lock()
+	defer c.noticeMutex.Unlock()
+
+	if len(c.notices) == 0 {
+		return "", false
+	}
+	text := c.notices[0]
+	c.notices = c.notices[1:]
+	return text, true
+}
+
+// SendChat sends a chat message, which the server echoes back once it has
+// been accepted
+func (c *Client) SendChat(scope protocol.ChatScope, text string) error {
+	return c.write(&protocol.Chat{Scope: scope, Text: text})
+}
+
+func (c *Client) pushChat(chat protocol.Chat) {
+	c.noticeMutex.Lock()
+	c.chat = append(c.chat, chat)
+	c.noticeMutex.Unlock()
+}
+
+// PopChat returns the oldest chat message that has not been shown yet
+func (c *Client) PopChat() (protocol.Chat, bool) {
+	c.noticeMutex.Lock()
+	defer c.noticeMutex.Unlock()
+
+	if len(c.chat) == 0 {
+		return protocol.Chat{}, false
+	}
+	chat := c.chat[0]
+	c.chat = c.chat[1:]
+	return chat, true
+}
+
+// SendWorldEvent lets the players on the same map know that we changed it
+func (c *Client) SendWorldEvent(event protocol.WorldEvent) {
+	if c.Active() {
+		c.write(&event)
+	}
+}
+
+// SendRequest asks the player with the given id to do something together.
+// The answer, or the reason there is none, arrives as another request.
+func (c *Client) SendRequest(to int, interaction protocol.Interaction) {
+	if c.Active() {
+		c.write(&protocol.Request{To: to, Interaction: interaction, Status: protocol.RequestAsked})
+	}
+}
+
+// AnswerRequest accepts or declines a request from another player
+func (c *Client) AnswerRequest(req protocol.Request, accept bool) {
+	status := protocol.RequestDeclined
+	if accept {
+		status = protocol.RequestAccepted
+	}
+	if c.Active() {
+		c.write(&protocol.Request{To: req.From, Interaction: req.Interaction, Status: status})
+	}
+}
+
+// PopRequest returns the oldest change to a request that has not been
+// shown yet
+func (c *Client) PopRequest() (protocol.Request, bool) {
+	c.noticeMutex.Lock()
+	defer c.noticeMutex.Unlock()
+
+	if len(c.requests) == 0 {
+		return protocol.Request{}, false
+	}
+	req := c.requests[0]
+	c.requests = c.requests[1:]
+	return req, true
+}
+
+func (c *Client) pushWorldChange(change worldChange) {
+	c.noticeMutex.Lock()
+	c.worldChanges = append(c.worldChanges, change)
+	c.noticeMutex.Unlock()
+}
+
+func (c *Client) popWorldChange() (worldChange, bool) {
+	c.noticeMutex.Lock()
+	defer c.noticeMutex.Unlock()
+
+	if len(c.worldChanges) == 0 {
+		return worldChange{}, false
+	}
+	change := c.worldChanges[0]
+	c.worldChanges = c.worldChanges[1:]
+	return change, true
+}
+
+func (c *Client) popCorrection() *protocol.Correction {
+	c.noticeMutex.Lock()
+	defer c.noticeMutex.Unlock()
+
+	correction := c.correction
+	c.correction = nil
+	return correction
+}
+
+func (c *Client) popSpawn() *protocol.Spawn {
+	c.noticeMutex.Lock()
+	defer c.noticeMutex.Unlock()
+
+	spawn := c.spawn
+	c.spawn = nil
+	return spawn
+}
+
 func (c *Client) Disconnect() {
-	if !c.Active {
+	c.connMutex.Lock()
+	c.attempt++
+	c.connMutex.Unlock()
+
+	if ConnectionState(atomic.SwapInt32(&c.state, int32(Offline))) == Offline {
 		return
 	}
 	log.Println("Disconnecting...")
-	c.Active = false
-	c.rw.WriteByte(0)
-	c.conn.Close()
+	c.connMutex.Lock()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	if c.udp != nil {
+		c.udp.Close()
+		c.udp = nil
+	}
+	c.connMutex.Unlock()
 }
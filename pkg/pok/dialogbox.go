@@ -39,6 +39,8 @@ type DialogBox struct {
 	box *ebiten.Image
 	speed int
 	ticks int
+	choices []string	// shown below the text once it is done, if any
+	selected int
 }
 
 func NewDialogBox() DialogBox {
@@ -69,9 +71,23 @@ func NewDialogBox() DialogBox {
 func (d *DialogBox) PeekCollector(coll *dialog.DialogTreeCollector) {
 	result := coll.Peek()
 	d.SetString(result.Dialog)
+	d.choices = result.Choices
+	d.selected = 0
 	d.Hidden = false
 }
 
+// Select moves the selection between the choices, if there are any
+func (d *DialogBox) Select(delta int) {
+	if len(d.choices) == 0 {
+		return
+	}
+	d.selected = (d.selected + delta + len(d.choices)) % len(d.choices)
+}
+
+func (d *DialogBox) Selected() int {
+	return d.selected
+}
+
 func (d *DialogBox) SetString(str string) {
 	result := str
 	hasBreak := false
@@ -98,6 +114,7 @@ func (d *DialogBox) SetString(str string) {
 		d.dispStr = ""
 	}
 	d.fullStr = result
+	d.choices = nil
 }
 
 func (d *DialogBox) IsDone() bool {
@@ -138,8 +155,24 @@ func (d *DialogBox) Draw(target *ebiten.Image) {
 	dy := constants.DisplaySizeY - d.box.Bounds().Dy() - 4
 	opt.GeoM.Translate(float64(dx), float64(dy))
 	target.DrawImage(d.box, opt)
-	text.Draw(target, d.dispStr, d.font, dx + textXDelta + 1, dy + textYDelta, bgClr)
-	text.Draw(target, d.dispStr, d.font, dx + textXDelta, dy + textYDelta + 1, bgClr)
-	text.Draw(target, d.dispStr, d.font, dx + textXDelta + 1, dy + textYDelta + 1, bgClr)
-	text.Draw(target, d.dispStr, d.font, dx + textXDelta, dy + textYDelta, fgClr)
+	d.drawText(target, d.dispStr, dx + textXDelta, dy + textYDelta)
+
+	if len(d.choices) > 0 && d.IsDone() {
+		line := ""
+		for i, choice := range d.choices {
+			if i == d.selected {
+				line += "> " + choice + "  "
+			} else {
+				line += "  " + choice + "  "
+			}
+		}
+		d.drawText(target, line, dx + textXDelta, dy + textYDelta * 2)
+	}
+}
+
+func (d *DialogBox) drawText(target *ebiten.Image, str string, x, y int) {
+	text.Draw(target, str, d.font, x + 1, y, bgClr)
+	text.Draw(target, str, d.font, x, y + 1, bgClr)
+	text.Draw(target, str, d.font, x + 1, y + 1, bgClr)
+	text.Draw(target, str, d.font, x, y, fgClr)
 }
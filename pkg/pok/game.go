@@ -1,48 +1,72 @@
 package pok
 
 import (
+	"errors"
 	"github.com/atemmel/pok/pkg/constants"
 	"github.com/atemmel/pok/pkg/debug"
+	"github.com/atemmel/pok/pkg/fonts"
 	"github.com/atemmel/pok/pkg/jobs"
+	"github.com/atemmel/pok/pkg/protocol"
 	"github.com/atemmel/pok/pkg/textures"
 	"github.com/hajimehoshi/ebiten/v2"
+	"golang.org/x/image/font"
 	"image"
+	"log"
 	"math"
+	"os"
+	"time"
 )
 
 var DrawDebugInfo = false
 
+var nameplateFont font.Face
+
+// ErrQuit is returned from Update when the player quits the game
+var ErrQuit = errors.New("quit")
+
 type Game struct {
 	Ows OverworldState
-	As GameState
+	States StateStack
 	Player Player
 	Client Client
 	Rend Renderer
 	Audio Audio
 	Dialog DialogBox
+	Chat ChatBox
+	Slot int	// that Save writes to
+	Online bool	// if the game connects to a server once started
+	secret string	// the password of the player online
+	clientDone chan struct{}	// closed once the client of the last session has stopped, nil if there was none
+	connected chan int	// gets the id of the player once the session started last is connected, or -1
+	World WorldCache
+	Options Options
+	lastSave *SaveData	// what was last saved or continued from, to tell if there is unsaved progress
 }
 
 func CreateGame() *Game {
 	g := &Game{}
-	g.As = &g.Ows
+	g.States.Push(&g.Ows)
+	g.World = NewWorldCache()
 	var err error
-	playerImg, err = textures.LoadWithError(constants.CharacterImagesDir + "trchar000.png")
-	debug.Assert(err)
-	playerRunningImg, err = textures.LoadWithError(constants.CharacterImagesDir + "boy_run.png")
-	debug.Assert(err)
-	playerBikingImg, err = textures.LoadWithError(constants.CharacterImagesDir + "boy_bike.png")
-	debug.Assert(err)
+	spriteSets = []spriteSet{
+		loadSpriteSet("Boy", "trchar000.png", "boy_run.png", "boy_bike.png", "boy_surf.png"),
+		loadSpriteSet("Girl", "trchar001.png", "girl_run.png", "girl_bike.png", "girl_surf.png"),
+	}
 	beachSplashImg, err = textures.LoadWithError(constants.ImagesDir + "water_effect.png")
 	debug.Assert(err)
-	playerSurfingImg, err = textures.LoadWithError(constants.CharacterImagesDir + "boy_surf.png")
-	debug.Assert(err)
 	sharpedoImg, err = textures.LoadWithError(constants.ImagesDir + "surf_sharpedo.png")
 	debug.Assert(err)
 	playerUsingHMImg, err = textures.LoadWithError(constants.ImagesDir + "hm_anim.png")
 	debug.Assert(err)
 
-	activePlayerImg = playerImg
 	g.Dialog = NewDialogBox()
+	g.Chat = NewChatBox()
+	g.Options, err = ReadOptions()
+	if err != nil && !os.IsNotExist(err) {
+		log.Println("Could not read options:", err)
+	}
+	nameplateFont, err = fonts.LoadFont(constants.FontsDir + "pokemon_pixel_font.ttf", 16)
+	debug.Assert(err)
 
 	// animate water splashes
 	jobs.Add(jobs.Job{
@@ -95,10 +119,8 @@ func (g *Game) TileIsOccupied(x int, y int, z int) bool {
 		return true
 	}
 
-	for _, p := range g.Client.playerMap.players {
-		if p.Char.X == x && p.Char.Y == y {
-			return true
-		}
+	if _, ok := g.Client.PlayerAt(g.Player.Location, x, y, z); ok {
+		return true
 	}
 
 	for i := range g.Ows.tileMap.Npcs {
@@ -128,22 +150,42 @@ func (g *Game) TileIsOccupied(x int, y int, z int) bool {
 }
 
 func (g *Game) Update() error {
-	err := g.As.GetInputs(g)
+	err := g.States.Top().GetInputs(g)
 	if err != nil {
 		return err
 	}
-	err = g.As.Update(g)
+	// The inputs may have popped every state there was
+	if g.States.Len() == 0 {
+		return ErrQuit
+	}
+	err = g.States.Top().Update(g)
 	if err != nil {
 		return err
 	}
+
+	// Connecting happens in the background, but its outcome is only taken
+	// in here
+	select {
+		case id := <-g.connected:
+			g.Player.Id = id
+			g.Player.Connected = id >= 0
+		default:
+	}
+
+	// Whatever is on top, the server must keep hearing from us
+	if g.Client.Active() {
+		g.Client.SyncPlayer(&g.Player)
+		g.Client.InterpolatePlayers(time.Now())
+	}
 	return nil
 }
 
 func (g *Game) Draw(screen *ebiten.Image) {
-	g.As.Draw(g, screen)
+	g.States.Draw(g, screen)
 }
 
 func (g *Game) Load(str string, entrypoint int) {
+	g.remember()
 	err := g.Ows.tileMap.OpenFile(str)
 	debug.Assert(err)
 	g.Player.Location = str
@@ -162,25 +204,128 @@ func (g *Game) Load(str string, entrypoint int) {
 		constants.DisplaySizeY,
 		2,
 	)
-	g.Player.Char.hasUsedStrength = false
+	g.restore()
 
 	g.Rend.SetEffect(GetActiveEffect())
-	switch g.Ows.tileMap.WeatherKind {
+	g.SetWeather(g.Ows.tileMap.WeatherKind)
+}
+
+func (g *Game) SetWeather(kind WeatherKind) {
+	switch kind {
 	case Hail:
 		g.Ows.weather = CreateHailWeather(&g.Rend)
 	case Rain:
 		g.Ows.weather = CreateRainWeather(&g.Rend)
+	default:
+		g.Ows.weather = nil
 	}
 }
 
-func (g *Game) Save() {
-	/*
-	bytes, err := json.Marshal(g.Ows.tileMap)
-	if err != nil {
-		fmt.Println(err)
+// Rest brings back every rock, tree and boulder that has been dealt with,
+// unless it stays gone forever
+func (g *Game) Rest() {
+	if g.Online {
+		// The world belongs to the server
+		return
 	}
-	ioutil.WriteFile(g.Player.Location, bytes, 0644)
-	*/
+	g.remember()
+	g.World.Rest()
+	err := g.Ows.tileMap.OpenFile(g.Player.Location)
+	debug.Assert(err)
+	g.restore()
+}
+
+// remember stores what has been done to the current map in the world cache.
+// Online, the server keeps track of the world instead, and it is not mixed
+// with what was done offline.
+func (g *Game) remember() {
+	if g.Online || g.Player.Location == "" {
+		return
+	}
+	g.World.Remember(g.Player.Location, &g.Ows.tileMap, g.Player.Char.hasUsedStrength)
+}
+
+// restore brings the current map up to date with the world cache, unless
+// the game is online
+func (g *Game) restore() {
+	g.Player.Char.hasUsedStrength = false
+	if g.Online {
+		return
+	}
+	g.Player.Char.hasUsedStrength = g.World.Restore(g.Player.Location, &g.Ows.tileMap)
+}
+
+// NewGame starts over as a new player at an entry of a map. A player
+// without a name, as when a map is given on the command line, is made up
+// one.
+func (g *Game) NewGame(name string, sheet int, location string, entry int) {
+	if name == "" {
+		name = newGuestName()
+	}
+	g.Player.Name = name
+	g.Player.Sheet = sheet
+	g.secret = newSecret()
+	g.World = NewWorldCache()
+	g.lastSave = nil
+	g.Load(location, entry)
+	g.start()
+}
+
+// start hands the game over to the overworld, connecting to the server in
+// the background if the game is online
+func (g *Game) start() {
+	g.States.Replace(&g.Ows)
+	if !g.Online {
+		return
+	}
+
+	// Taken here, so that QuitToTitle cancels connecting even if it has
+	// not started yet
+	name, secret, attempt := g.Player.Name, g.secret, g.Client.Attempt()
+	previous := g.clientDone
+	done := make(chan struct{})
+	connected := make(chan int, 1)
+	g.clientDone = done
+	g.connected = connected
+	go func() {
+		// The client of the last session must let go first
+		if previous != nil {
+			<-previous
+		}
+		connected <- g.Client.Connect(name, secret, attempt)
+		// Keeps reconnecting in the background if the connection is lost
+		g.Client.ReadPlayer()
+		close(done)
+	}()
+}
+
+// QuitToTitle ends the play session without saving, going back to the
+// title screen
+func (g *Game) QuitToTitle() {
+	g.Client.Disconnect()
+	g.connected = nil
+	g.Player = Player{}
+	g.secret = ""
+	g.Ows = OverworldState{}
+	g.World = NewWorldCache()
+	g.lastSave = nil
+	g.Dialog = NewDialogBox()
+	g.Chat = NewChatBox()
+	g.ApplyOptions()
+	g.States = StateStack{}
+	g.ShowTitle()
+}
+
+// placePlayer loads a map and puts the player on it, just as it was
+func (g *Game) placePlayer(location string, x, y, z int, facing Direction, mode protocol.MovementMode) {
+	g.Load(location, -1)
+	c := &g.Player.Char
+	c.X, c.Y, c.Z = x, y, z
+	c.Gx = float64(c.X * constants.TileSize)
+	c.Gy = float64(c.Y * constants.TileSize)
+	c.isBiking = mode == protocol.Biking
+	c.isSurfing = mode == protocol.Surfing
+	c.SetDirection(facing)
 }
 
 //TODO: Maybe throw away?
@@ -207,7 +352,7 @@ func (g *Game) DrawPlayer(player *Player) {
 
 	g.Rend.Draw(&RenderTarget{
 		playerOpt,
-		activePlayerImg,
+		player.sprite(),
 		&playerRect,
 		x,
 		y + waterBobOffsetY,
@@ -259,7 +404,7 @@ func (g *Game) DrawPlayer(player *Player) {
 
 		// Code for repeating mouth cycle on holding sprint
 		stepW := 0
-		if player.Char.isRunning || holdingSprint() {
+		if player.sprinting {
 			// Code for twice as fast repeating mouth cycle
 			/*
 			if player.Char.Tx / (constants.TileSize * 2) % 2 == 0 {
@@ -0,0 +1,116 @@
+package pok
+
+import (
+	"github.com/atemmel/pok/pkg/constants"
+	"github.com/atemmel/pok/pkg/protocol"
+	"math"
+	"time"
+)
+
+const (
+	// Remote players are drawn this far in the past, so that there usually
+	// are two snapshots to interpolate between
+	interpolationDelay = 100 * time.Millisecond
+	// How long a remote player keeps moving on its own when updates stop
+	maxExtrapolation = 250 * time.Millisecond
+	// Any further off than this and the remote player is teleported instead
+	snapDistance = constants.TileSize * 3
+	nSnapshots = 32
+)
+
+type snapshot struct {
+	at time.Time
+	state protocol.PlayerState
+}
+
+// snapshotBuffer holds the latest states recieved for a remote player,
+// oldest first
+type snapshotBuffer struct {
+	snapshots []snapshot
+	shownX, shownY float64
+	hasShown bool
+}
+
+func (b *snapshotBuffer) Push(at time.Time, state protocol.PlayerState) {
+	if n := len(b.snapshots); n > 0 {
+		last := b.snapshots[n - 1]
+		dx, dy := state.Gx - b.shownX, state.Gy - b.shownY
+
+		if state.Location != last.state.Location || (b.hasShown && math.Hypot(dx, dy) > snapDistance) {
+			b.snapshots = b.snapshots[:0]
+		} else if at.Sub(last.at) > interpolationDelay {
+			// The player has been standing still, restamp the old state so
+			// that the movement starts from there rather than jumping ahead
+			last.at = at.Add(-interpolationDelay / 2)
+			b.snapshots = append(b.snapshots, last)
+		}
+	}
+
+	if len(b.snapshots) >= nSnapshots {
+		n := copy(b.snapshots, b.snapshots[len(b.snapshots) - nSnapshots + 1:])
+		b.snapshots = b.snapshots[:n]
+	}
+
+	b.snapshots = append(b.snapshots, snapshot{at, state})
+}
+
+// Sample returns the state to draw at the given time. Positions are
+// interpolated (or briefly extrapolated), while everything that relates to
+// collision is taken from the newest snapshot.
+func (b *snapshotBuffer) Sample(now time.Time) (protocol.PlayerState, bool) {
+	n := len(b.snapshots)
+	if n == 0 {
+		return protocol.PlayerState{}, false
+	}
+
+	renderAt := now.Add(-interpolationDelay)
+	newest := b.snapshots[n - 1]
+	result := newest.state
+
+	if n == 1 || !renderAt.After(b.snapshots[0].at) {
+		result = b.snapshots[0].state
+	} else if !renderAt.Before(newest.at) {
+		ahead := renderAt.Sub(newest.at)
+		prev := b.snapshots[n - 2]
+		span := newest.at.Sub(prev.at)
+
+		if ahead <= maxExtrapolation && span > 0 {
+			frac := float64(ahead) / float64(span)
+			targetX := float64(newest.state.X * constants.TileSize)
+			targetY := float64(newest.state.Y * constants.TileSize)
+			result.Gx = extrapolate(prev.state.Gx, newest.state.Gx, frac, targetX)
+			result.Gy = extrapolate(prev.state.Gy, newest.state.Gy, frac, targetY)
+		}
+	} else {
+		i := n - 2
+		for b.snapshots[i].at.After(renderAt) {
+			i--
+		}
+		from, to := b.snapshots[i], b.snapshots[i + 1]
+		frac := float64(renderAt.Sub(from.at)) / float64(to.at.Sub(from.at))
+
+		result = from.state
+		result.Gx = lerp(from.state.Gx, to.state.Gx, frac)
+		result.Gy = lerp(from.state.Gy, to.state.Gy, frac)
+		result.OffsetY = lerp(from.state.OffsetY, to.state.OffsetY, frac)
+	}
+
+	result.Location = newest.state.Location
+	result.X, result.Y, result.Z = newest.state.X, newest.state.Y, newest.state.Z
+
+	b.shownX, b.shownY = result.Gx, result.Gy
+	b.hasShown = true
+	return result, true
+}
+
+// extrapolate continues the motion from a to b, but never past target, since
+// characters always come to a halt on a tile
+func extrapolate(a, b, frac, target float64) float64 {
+	delta := (b - a) * frac
+	if delta > 0 && b + delta > target {
+		return math.Max(b, target)
+	} else if delta < 0 && b + delta < target {
+		return math.Min(b, target)
+	}
+	return b + delta
+}
@@ -0,0 +1,61 @@
+package pok
+
+import (
+	"github.com/atemmel/pok/pkg/protocol"
+	"testing"
+	"time"
+)
+
+func stateAt(x, y int, gx, gy float64) protocol.PlayerState {
+	return protocol.PlayerState{Location: "test", X: x, Y: y, Gx: gx, Gy: gy}
+}
+
+func TestSnapshotInterpolation(t *testing.T) {
+	start := time.Unix(1000, 0)
+	b := snapshotBuffer{}
+	b.Push(start, stateAt(1, 0, 0, 0))
+	b.Push(start.Add(50 * time.Millisecond), stateAt(1, 0, 8, 0))
+
+	type sampleTest struct {
+		At time.Duration
+		Want float64
+	}
+
+	tests := []sampleTest{
+		{0, 0},
+		{interpolationDelay, 0},
+		{interpolationDelay + 25 * time.Millisecond, 4},
+		{interpolationDelay + 50 * time.Millisecond, 8},
+		// extrapolated, but not past the tile the character is heading to
+		{interpolationDelay + 75 * time.Millisecond, 12},
+		{interpolationDelay + 150 * time.Millisecond, 16},
+		// extrapolated for too long, fall back to what we know
+		{interpolationDelay + 50 * time.Millisecond + maxExtrapolation + time.Millisecond, 8},
+	}
+
+	for _, test := range tests {
+		state, ok := b.Sample(start.Add(test.At))
+		if !ok || state.Gx != test.Want {
+			t.Errorf("Sample at %v gave x %f, expected %f", test.At, state.Gx, test.Want)
+		}
+	}
+}
+
+func TestSnapshotSnapsOnLargeError(t *testing.T) {
+	start := time.Unix(1000, 0)
+	b := snapshotBuffer{}
+	b.Push(start, stateAt(0, 0, 0, 0))
+	b.Sample(start)
+
+	b.Push(start.Add(10 * time.Millisecond), stateAt(20, 0, 320, 0))
+	state, _ := b.Sample(start.Add(10 * time.Millisecond))
+	if state.Gx != 320 {
+		t.Errorf("Expected snap to x 320, got %f", state.Gx)
+	}
+
+	b.Push(start.Add(20 * time.Millisecond), protocol.PlayerState{Location: "other"})
+	state, _ = b.Sample(start.Add(20 * time.Millisecond))
+	if state.Location != "other" || state.Gx != 0 {
+		t.Errorf("Expected snap on map change, got %+v", state)
+	}
+}
@@ -0,0 +1,83 @@
+package pok
+
+import (
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+	"golang.org/x/image/font"
+	"image/color"
+)
+
+const (
+	menuLineHeight = 18
+	menuPadding = 8
+	menuBorder = 2
+)
+
+var menuBgClr = color.RGBA{248, 248, 248, 255}
+
+func pressedUp() bool {
+	return inpututil.IsKeyJustPressed(bindings.Up.Key()) || inpututil.IsKeyJustPressed(ebiten.KeyUp) || inpututil.IsKeyJustPressed(ebiten.KeyK)
+}
+
+func pressedDown() bool {
+	return inpututil.IsKeyJustPressed(bindings.Down.Key()) || inpututil.IsKeyJustPressed(ebiten.KeyDown) || inpututil.IsKeyJustPressed(ebiten.KeyJ)
+}
+
+func pressedBack() bool {
+	return inpututil.IsKeyJustPressed(ebiten.KeyEscape) || pressedItem()
+}
+
+// Menu is a list of items to choose one from, drawn as a box
+type Menu struct {
+	Items []string
+	Selected int
+}
+
+// Update moves the selection, returning the index of the item chosen, if
+// any, or -1
+func (m *Menu) Update() int {
+	if len(m.Items) == 0 {
+		return -1
+	}
+	if pressedUp() {
+		m.Selected = (m.Selected + len(m.Items) - 1) % len(m.Items)
+	} else if pressedDown() {
+		m.Selected = (m.Selected + 1) % len(m.Items)
+	} else if pressedInteract() {
+		return m.Selected
+	}
+	return -1
+}
+
+// Size returns the size of the box the menu is drawn in
+func (m *Menu) Size() (int, int) {
+	w := 0
+	for _, item := range m.Items {
+		if iw := font.MeasureString(nameplateFont, "> " + item).Ceil(); iw > w {
+			w = iw
+		}
+	}
+	return w + menuPadding * 2, len(m.Items) * menuLineHeight + menuPadding * 2
+}
+
+// Draw draws the menu with its top left corner at x, y
+func (m *Menu) Draw(target *ebiten.Image, x, y int) {
+	w, h := m.Size()
+	ebitenutil.DrawRect(target, float64(x), float64(y), float64(w), float64(h), fgClr)
+	ebitenutil.DrawRect(target, float64(x + menuBorder), float64(y + menuBorder), float64(w - menuBorder * 2), float64(h - menuBorder * 2), menuBgClr)
+
+	for i, item := range m.Items {
+		line := "  " + item
+		if i == m.Selected {
+			line = "> " + item
+		}
+		drawMenuText(target, line, x + menuPadding, y + menuPadding + (i + 1) * menuLineHeight - 4)
+	}
+}
+
+func drawMenuText(target *ebiten.Image, str string, x, y int) {
+	text.Draw(target, str, nameplateFont, x + 1, y + 1, bgClr)
+	text.Draw(target, str, nameplateFont, x, y, fgClr)
+}
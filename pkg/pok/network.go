@@ -2,28 +2,47 @@ package pok
 
 import (
 	"encoding/json"
+	"github.com/atemmel/pok/pkg/protocol"
+	"github.com/atemmel/pok/pkg/transport"
 	"io/ioutil"
 )
 
 const ConfigDir = "./"
-const ServerConfigFile = "config_server.json"
 const ClientConfigFile = "config_client.json"
 
-type ServerConfig struct {
-	Url string
-	Port string
-}
+const (
+	DefaultTickRate = 20
+	DefaultHeartbeatInterval = 1000
+	DefaultStartMap = "old.json"
+)
+
+// Transports a client can connect with
+const (
+	TcpTransport = "tcp"
+	WebSocketTransport = "ws"
+	SecureWebSocketTransport = "wss"
+)
 
 type ClientConfig struct {
 	ServerUrl string
 	ServerPort string
+	Name string	// set by Connect to the player of the game
+	Password string	// of Name, set by Connect
+	Room string	// to join, the default room of the server if empty
+	TickRate int	// max player state uploads per second
+	HeartbeatInterval int	// in milliseconds
+	Transport string	// tcp, ws or wss, tcp if empty
+	WebSocketPath string
+	Udp bool	// send player states over udp, if the server allows it
+	Spawn bool	// be moved to where the server has the player on connecting, instead of being corrected there on the first move
+	StartMap string	// in TileMapDir, where a new game starts
+	StartEntry int	// on StartMap
+	Network transport.Network `json:"-"`	// transport.System if nil
 }
 
-//TODO A lot of code dupe here
-
-func ReadServerConfig() (ServerConfig, error) {
-	var conf ServerConfig
-	data, err := ioutil.ReadFile(ConfigDir + ServerConfigFile)
+func ReadClientConfig() (ClientConfig, error) {
+	var conf ClientConfig
+	data, err := ioutil.ReadFile(ConfigDir + ClientConfigFile)
 	if err != nil {
 		return conf, err
 	}
@@ -33,21 +52,32 @@ func ReadServerConfig() (ServerConfig, error) {
 		return conf, err
 	}
 
+	conf.setDefaults()
 	return conf, nil
 }
 
-func ReadClientConfig() (ClientConfig, error) {
+func (conf *ClientConfig) setDefaults() {
+	if conf.TickRate <= 0 {
+		conf.TickRate = DefaultTickRate
+	}
 
-	var conf ClientConfig
-	data, err := ioutil.ReadFile(ConfigDir + ClientConfigFile)
-	if err != nil {
-		return conf, err
+	if conf.HeartbeatInterval <= 0 {
+		conf.HeartbeatInterval = DefaultHeartbeatInterval
 	}
 
-	err = json.Unmarshal(data, &conf)
-	if err != nil {
-		return conf, err
+	if conf.Transport == "" {
+		conf.Transport = TcpTransport
 	}
 
-	return conf, nil
+	if conf.WebSocketPath == "" {
+		conf.WebSocketPath = protocol.DefaultWebSocketPath
+	}
+
+	if conf.StartMap == "" {
+		conf.StartMap = DefaultStartMap
+	}
+
+	if conf.Network == nil {
+		conf.Network = transport.System
+	}
 }
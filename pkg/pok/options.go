@@ -0,0 +1,340 @@
+package pok
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+const OptionsFile = "options.json"
+
+const maxVolume = 10
+
+var textSpeedNames = []string{
+	TextSlow: "Slow",
+	TextNormal: "Normal",
+	TextFast: "Fast",
+	TextInstant: "Instant",
+}
+
+// Binding is a key that can be changed, stored by its name
+type Binding ebiten.Key
+
+func (b Binding) Key() ebiten.Key {
+	return ebiten.Key(b)
+}
+
+func (b Binding) String() string {
+	return ebiten.Key(b).String()
+}
+
+func (b Binding) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+func (b *Binding) UnmarshalText(data []byte) error {
+	for k := ebiten.Key(0); k <= ebiten.KeyMax; k++ {
+		if k.String() == string(data) {
+			*b = Binding(k)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown key %s", data)
+}
+
+// KeyBindings are the keys that the player can change. The arrow keys
+// always work as well.
+type KeyBindings struct {
+	Up, Down, Left, Right Binding
+	Interact Binding
+	Item Binding
+	Sprint Binding
+	MapChat Binding
+	GlobalChat Binding
+}
+
+var DefaultKeyBindings = KeyBindings{
+	Binding(ebiten.KeyW),
+	Binding(ebiten.KeyS),
+	Binding(ebiten.KeyA),
+	Binding(ebiten.KeyD),
+	Binding(ebiten.KeyZ),
+	Binding(ebiten.KeyX),
+	Binding(ebiten.KeyShift),
+	Binding(ebiten.KeyT),
+	Binding(ebiten.KeyY),
+}
+
+// The bindings in use
+var bindings = DefaultKeyBindings
+
+var bindingNames = []string{"Up", "Down", "Left", "Right", "Interact", "Item", "Sprint", "Map chat", "Global chat"}
+
+// As the bindings are named in OptionsFile
+var bindingFields = []string{"Up", "Down", "Left", "Right", "Interact", "Item", "Sprint", "MapChat", "GlobalChat"}
+
+// Keys that always do the same thing, so they can not be bound
+var reservedKeys = map[ebiten.Key]bool{
+	ebiten.KeyEscape: true,
+	ebiten.KeyEnter: true,
+	ebiten.KeyBackspace: true,
+	ebiten.KeyUp: true,
+	ebiten.KeyDown: true,
+	ebiten.KeyLeft: true,
+	ebiten.KeyRight: true,
+	ebiten.KeyH: true,
+	ebiten.KeyJ: true,
+	ebiten.KeyK: true,
+	ebiten.KeyL: true,
+	ebiten.KeyE: true,
+}
+
+// list returns the bindings in the same order as bindingNames
+func (k *KeyBindings) list() []*Binding {
+	return []*Binding{&k.Up, &k.Down, &k.Left, &k.Right, &k.Interact, &k.Item, &k.Sprint, &k.MapChat, &k.GlobalChat}
+}
+
+// conflict returns why a key can not be bound to the binding at index i,
+// or an empty string if it can
+func (k *KeyBindings) conflict(i int, key ebiten.Key) string {
+	if reservedKeys[key] {
+		return key.String() + " can not be changed"
+	}
+	for j, b := range k.list() {
+		if j != i && b.Key() == key {
+			return key.String() + " is already used for " + bindingNames[j]
+		}
+	}
+	return ""
+}
+
+// Options are the settings of the game that the player can change
+type Options struct {
+	TextSpeed int	// TextSlow, TextNormal, TextFast or TextInstant
+	Volume int	// from 0 to maxVolume
+	Keys KeyBindings
+}
+
+var DefaultOptions = Options{TextNormal, 2, DefaultKeyBindings}
+
+// ReadOptions reads the options, or returns the defaults if there are none.
+// Options that can not be read are left at their defaults, and the error
+// says which.
+func ReadOptions() (Options, error) {
+	opts := DefaultOptions
+	data, err := ioutil.ReadFile(ConfigDir + OptionsFile)
+	if err != nil {
+		return opts, err
+	}
+
+	var fields struct {
+		TextSpeed json.RawMessage
+		Volume json.RawMessage
+		Keys map[string]json.RawMessage
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return opts, err
+	}
+
+	var reset []string
+	read := func(name string, raw json.RawMessage, v interface{}) {
+		if raw != nil && json.Unmarshal(raw, v) != nil {
+			reset = append(reset, name)
+		}
+	}
+
+	read("TextSpeed", fields.TextSpeed, &opts.TextSpeed)
+	if opts.TextSpeed < 0 || opts.TextSpeed >= len(textSpeedNames) {
+		opts.TextSpeed = DefaultOptions.TextSpeed
+		reset = append(reset, "TextSpeed")
+	}
+	read("Volume", fields.Volume, &opts.Volume)
+	if opts.Volume < 0 || opts.Volume > maxVolume {
+		opts.Volume = DefaultOptions.Volume
+		reset = append(reset, "Volume")
+	}
+
+	keys := opts.Keys.list()
+	defaults := DefaultKeyBindings.list()
+	for i, field := range bindingFields {
+		read(field, fields.Keys[field], keys[i])
+		if opts.Keys.conflict(i, keys[i].Key()) != "" {
+			*keys[i] = *defaults[i]
+			reset = append(reset, field)
+		}
+	}
+	// A binding put back may have been taken by another one
+	for i := range keys {
+		if opts.Keys.conflict(i, keys[i].Key()) != "" {
+			opts.Keys = DefaultKeyBindings
+			reset = append(reset, "Keys")
+			break
+		}
+	}
+
+	if len(reset) > 0 {
+		return opts, fmt.Errorf("could not read %s, so they were reset", strings.Join(reset, ", "))
+	}
+	return opts, nil
+}
+
+func WriteOptions(opts Options) error {
+	data, err := json.MarshalIndent(opts, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(ConfigDir + OptionsFile, data, 0644)
+}
+
+// ApplyOptions puts the options of the game into effect
+func (g *Game) ApplyOptions() {
+	g.Dialog.speed = g.Options.TextSpeed
+	g.Audio.SetVolume(float64(g.Options.Volume) / maxVolume)
+	bindings = g.Options.Keys
+}
+
+// OptionsState lets the player change the options, drawn over the state
+// it was opened from
+type OptionsState struct {
+	menu Menu
+	keys bool	// if the key bindings are being changed
+	rebinding int	// index of the binding waiting for a key, or -1
+	message string	// why the last key could not be bound
+}
+
+func NewOptionsState() *OptionsState {
+	return &OptionsState{rebinding: -1}
+}
+
+func (o *OptionsState) GetInputs(g *Game) error {
+	if o.rebinding >= 0 {
+		o.rebind(g)
+		return nil
+	}
+
+	if pressedBack() {
+		o.leave(g)
+		return nil
+	}
+
+	if o.keys {
+		chosen := o.menu.Update()
+		if chosen == len(bindingNames) {
+			o.keys = false
+			o.menu.Selected = 2
+		} else if chosen >= 0 {
+			o.rebinding = chosen
+		}
+		return nil
+	}
+
+	delta := 0
+	if pressedPrevious() {
+		delta = -1
+	} else if pressedNext() {
+		delta = 1
+	}
+
+	opts := &g.Options
+	switch o.menu.Update() {
+		case 0:
+			delta = 1
+		case 2:
+			o.keys = true
+			o.menu.Selected = 0
+			return nil
+		case 3:
+			o.leave(g)
+			return nil
+	}
+
+	switch o.menu.Selected {
+		case 0:
+			opts.TextSpeed = (opts.TextSpeed + delta + len(textSpeedNames)) % len(textSpeedNames)
+		case 1:
+			opts.Volume += delta
+			if opts.Volume < 0 {
+				opts.Volume = 0
+			} else if opts.Volume > maxVolume {
+				opts.Volume = maxVolume
+			}
+	}
+	if delta != 0 {
+		g.ApplyOptions()
+	}
+	return nil
+}
+
+// rebind waits for a key to bind, or for escape to keep the old one
+func (o *OptionsState) rebind(g *Game) {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		o.rebinding = -1
+		o.message = ""
+		return
+	}
+
+	for k := ebiten.Key(0); k <= ebiten.KeyMax; k++ {
+		if inpututil.IsKeyJustPressed(k) {
+			o.message = g.Options.Keys.conflict(o.rebinding, k)
+			if o.message != "" {
+				return
+			}
+			*g.Options.Keys.list()[o.rebinding] = Binding(k)
+			o.rebinding = -1
+			g.ApplyOptions()
+			return
+		}
+	}
+}
+
+func (o *OptionsState) leave(g *Game) {
+	if o.keys {
+		o.keys = false
+		o.menu.Selected = 2
+		return
+	}
+
+	if err := WriteOptions(g.Options); err != nil {
+		log.Println("Could not save options:", err)
+	}
+	g.States.Pop()
+}
+
+func (o *OptionsState) Update(g *Game) error {
+	if o.keys {
+		o.menu.Items = o.menu.Items[:0]
+		for i, b := range g.Options.Keys.list() {
+			key := b.String()
+			if i == o.rebinding {
+				key = "press a key"
+			}
+			o.menu.Items = append(o.menu.Items, bindingNames[i] + ": " + key)
+		}
+		o.menu.Items = append(o.menu.Items, "Back")
+		return nil
+	}
+
+	o.menu.Items = []string{
+		"Text speed: " + textSpeedNames[g.Options.TextSpeed],
+		fmt.Sprintf("Volume: %d", g.Options.Volume),
+		"Keys",
+		"Back",
+	}
+	return nil
+}
+
+func (o *OptionsState) DrawsUnder() bool {
+	return true
+}
+
+func (o *OptionsState) Draw(g *Game, screen *ebiten.Image) {
+	w, h := o.menu.Size()
+	o.menu.Draw(screen, screen.Bounds().Dx() - w - 4, 4)
+	if o.message != "" {
+		drawMenuText(screen, o.message, screen.Bounds().Dx() - w - 4, 4 + h + menuLineHeight)
+	}
+}
@@ -1,26 +1,22 @@
 package pok
 
 import (
-	"errors"
 	"fmt"
 	"github.com/atemmel/pok/pkg/constants"
 	"github.com/atemmel/pok/pkg/dialog"
 	"github.com/atemmel/pok/pkg/jobs"
+	"github.com/atemmel/pok/pkg/protocol"
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
 	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+	"golang.org/x/image/font"
 )
 
-var playerImg *ebiten.Image
-var playerRunningImg *ebiten.Image
-var playerBikingImg *ebiten.Image
-var playerSurfingImg *ebiten.Image
 var playerUsingHMImg *ebiten.Image
 var sharpedoImg *ebiten.Image
 var beachSplashImg *ebiten.Image
 
-var activePlayerImg *ebiten.Image
-
 var selectedHm int = None
 
 func aboutToUseHM() bool {
@@ -55,6 +51,8 @@ type OverworldState struct {
 	tileMap TileMap
 	collector dialog.DialogTreeCollector
 	weather Weather
+	asking int	// id of the player a request is being chosen for
+	incoming protocol.Request	// the request being answered
 	//hailWeather HailWeather
 }
 
@@ -75,31 +73,31 @@ func gamepadRight() bool {
 }
 
 func movingUp() bool {
-	return ebiten.IsKeyPressed(ebiten.KeyUp) || ebiten.IsKeyPressed(ebiten.KeyK) || ebiten.IsKeyPressed(ebiten.KeyW) || gamepadUp()
+	return ebiten.IsKeyPressed(ebiten.KeyUp) || ebiten.IsKeyPressed(ebiten.KeyK) || ebiten.IsKeyPressed(bindings.Up.Key()) || gamepadUp()
 }
 
 func movingDown() bool {
-	return ebiten.IsKeyPressed(ebiten.KeyDown) || ebiten.IsKeyPressed(ebiten.KeyJ) || ebiten.IsKeyPressed(ebiten.KeyS) || gamepadDown()
+	return ebiten.IsKeyPressed(ebiten.KeyDown) || ebiten.IsKeyPressed(ebiten.KeyJ) || ebiten.IsKeyPressed(bindings.Down.Key()) || gamepadDown()
 }
 
 func movingLeft() bool {
-	return ebiten.IsKeyPressed(ebiten.KeyLeft) || ebiten.IsKeyPressed(ebiten.KeyH) || ebiten.IsKeyPressed(ebiten.KeyA) || gamepadLeft()
+	return ebiten.IsKeyPressed(ebiten.KeyLeft) || ebiten.IsKeyPressed(ebiten.KeyH) || ebiten.IsKeyPressed(bindings.Left.Key()) || gamepadLeft()
 }
 
 func movingRight() bool {
-	return ebiten.IsKeyPressed(ebiten.KeyRight) || ebiten.IsKeyPressed(ebiten.KeyL) || ebiten.IsKeyPressed(ebiten.KeyD) || gamepadRight()
+	return ebiten.IsKeyPressed(ebiten.KeyRight) || ebiten.IsKeyPressed(ebiten.KeyL) || ebiten.IsKeyPressed(bindings.Right.Key()) || gamepadRight()
 }
 
 func holdingSprint() bool {
-	return ebiten.IsKeyPressed(ebiten.KeyShift) || ebiten.IsGamepadButtonPressed(0, ebiten.GamepadButton1)
+	return ebiten.IsKeyPressed(bindings.Sprint.Key()) || ebiten.IsGamepadButtonPressed(0, ebiten.GamepadButton1)
 }
 
 func pressedInteract() bool {
-	return inpututil.IsKeyJustPressed(ebiten.KeyZ) || inpututil.IsKeyJustPressed(ebiten.KeyE)
+	return inpututil.IsKeyJustPressed(bindings.Interact.Key()) || inpututil.IsKeyJustPressed(ebiten.KeyE)
 }
 
 func pressedItem() bool {
-	return inpututil.IsKeyJustPressed(ebiten.KeyX)
+	return inpututil.IsKeyJustPressed(bindings.Item.Key())
 }
 
 func (o *OverworldState) tryInteract(g *Game) {
@@ -121,6 +119,11 @@ func (o *OverworldState) tryInteract(g *Game) {
 			x++
 	}
 
+	// check other players
+	if o.tryInteractPlayer(x, y, z, g) {
+		return
+	}
+
 	// check npcs
 	for i := range o.tileMap.Npcs {
 		npc := &(o.tileMap.Npcs[i].Char)
@@ -247,13 +250,61 @@ func (o *OverworldState) talkWith(g *Game, npcIndex int) {
 	g.Dialog.Hidden = false
 }
 
+// showNotice presents the next message from the server, if any
+func (o *OverworldState) showNotice(g *Game) {
+	text, ok := g.Client.PopNotice()
+	if !ok {
+		return
+	}
+
+	o.collector = dialog.MakeDialogTreeCollector(&dialog.DialogTree{
+		&dialog.DialogNode{
+			Dialog: text,
+			Next: nil,
+		},
+	})
+
+	g.Dialog.PeekCollector(&o.collector)
+}
+
+func pressedMapChat() bool {
+	return inpututil.IsKeyJustPressed(bindings.MapChat.Key())
+}
+
+func pressedGlobalChat() bool {
+	return inpututil.IsKeyJustPressed(bindings.GlobalChat.Key())
+}
+
+func pressedPrevious() bool {
+	return inpututil.IsKeyJustPressed(ebiten.KeyLeft) || inpututil.IsKeyJustPressed(ebiten.KeyH) || inpututil.IsKeyJustPressed(bindings.Left.Key())
+}
+
+func pressedNext() bool {
+	return inpututil.IsKeyJustPressed(ebiten.KeyRight) || inpututil.IsKeyJustPressed(ebiten.KeyL) || inpututil.IsKeyJustPressed(bindings.Right.Key())
+}
+
 func (o *OverworldState) GetInputs(g *Game) error {
-	if ebiten.IsKeyPressed(ebiten.KeyEscape) {
-		return errors.New("")	//TODO Gotta be a better way to do this
+	if g.Chat.Input.Active {
+		g.Player.Char.TryStep(Static, g)
+		g.Chat.HandleInputs()
+		return nil
+	}
+
+	// Only just pressed, so that closing the chat does not pause the game
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.States.Push(NewPauseState())
+		return nil
 	}
 
 	if g.Dialog.Hidden {
 		o.CheckMovementInputs(g)
+		if g.Client.Active() {
+			if pressedMapChat() {
+				g.Chat.Open(g, protocol.MapChat)
+			} else if pressedGlobalChat() {
+				g.Chat.Open(g, protocol.GlobalChat)
+			}
+		}
 	} else {
 		o.CheckDialogInputs(g)
 	}
@@ -314,6 +365,15 @@ func (o *OverworldState) CheckDialogInputs(g *Game) {
 					goto COLLECT_AGAIN
 				}
 				break
+			case dialog.ChoiceDialogNodeId:
+				if pressedPrevious() {
+					g.Dialog.Select(-1)
+				} else if pressedNext() {
+					g.Dialog.Select(1)
+				} else if pressedInteract() {
+					o.collector.Choose(g.Dialog.Selected())
+					goto COLLECT_AGAIN
+				}
 			case dialog.EffectDialogNodeId:
 				if result.Opt == "surf" {
 					beginSurf(g)
@@ -323,6 +383,10 @@ func (o *OverworldState) CheckDialogInputs(g *Game) {
 					beginCut(g)
 				} else if result.Opt == "strength" {
 					beginStrength(g)
+				} else if result.Opt == "rest" {
+					g.Rest()
+				} else {
+					o.applyRequestEffect(g, result.Opt)
 				}
 				_ = o.collector.CollectOnce();
 				goto COLLECT_AGAIN
@@ -379,6 +443,13 @@ func beginRockSmash(g *Game) {
 	}
 
 	g.Ows.tileMap.Rocks[rockIndex].smashed = true
+	g.Client.SendWorldEvent(protocol.WorldEvent{
+		Location: g.Player.Location,
+		Event: protocol.RockSmashed,
+		X: x,
+		Y: y,
+		Z: z,
+	})
 }
 
 func beginCut(g *Game) {
@@ -405,13 +476,77 @@ func beginCut(g *Game) {
 	}
 
 	g.Ows.tileMap.CuttableTrees[treeIndex].cut = true
+	g.Client.SendWorldEvent(protocol.WorldEvent{
+		Location: g.Player.Location,
+		Event: protocol.TreeCut,
+		X: x,
+		Y: y,
+		Z: z,
+	})
 }
 
 func beginStrength(g *Game) {
 	g.Player.Char.hasUsedStrength = true
 }
 
+// applyWorldChanges catches the map up with what other players have done
+func (o *OverworldState) applyWorldChanges(g *Game) {
+	for {
+		change, ok := g.Client.popWorldChange()
+		if !ok {
+			return
+		}
+
+		if change.event != nil && change.event.Location == g.Player.Location {
+			o.tileMap.ApplyWorldEvent(change.event)
+		} else if change.state != nil && change.state.Location == g.Player.Location {
+			o.tileMap.ApplyWorldState(change.state)
+		} else if change.weather != nil && change.weather.Location == g.Player.Location {
+			g.SetWeather(WeatherKind(change.weather.Weather))
+		}
+	}
+}
+
+// applyCorrection puts the player back where the server last accepted it
+func (o *OverworldState) applyCorrection(g *Game) {
+	correction := g.Client.popCorrection()
+	if correction == nil {
+		return
+	}
+
+	if correction.Location != g.Player.Location {
+		g.Load(correction.Location, -1)
+	}
+
+	c := &g.Player.Char
+	c.X, c.Y, c.Z = correction.X, correction.Y, correction.Z
+	c.Gx = float64(c.X * constants.TileSize)
+	c.Gy = float64(c.Y * constants.TileSize)
+	c.OffsetY = 0
+	c.frames = 0
+	c.isWalking = false
+	c.isJumping = false
+	c.isTraversingStaircaseUp = false
+	c.isTraversingStaircaseDown = false
+}
+
+// applySpawn moves the player to where the server says it left off, which
+// it only says if the client config asks it to. Otherwise the player is
+// corrected there on its first move, if the server disagrees with the new
+// game or save.
+func (o *OverworldState) applySpawn(g *Game) {
+	spawn := g.Client.popSpawn()
+	if spawn == nil {
+		return
+	}
+
+	g.placePlayer(spawn.Location, spawn.X, spawn.Y, spawn.Z, Direction(spawn.Facing), spawn.Mode)
+}
+
 func (o *OverworldState) Update(g *Game) error {
+	o.applySpawn(g)
+	o.applyCorrection(g)
+	o.applyWorldChanges(g)
 	g.Player.Update(g)
 	jobs.TickAllOneFrame()
 	o.tileMap.Update(g)
@@ -419,9 +554,13 @@ func (o *OverworldState) Update(g *Game) error {
 		o.weather.Update()
 	}
 
-	if g.Client.Active {
-		g.Client.WritePlayer(&g.Player)
+	if g.Dialog.Hidden {
+		o.showNotice(g)
 	}
+	if g.Dialog.Hidden {
+		o.showRequest(g)
+	}
+	g.Chat.Update(g)
 
 	g.Dialog.Update()
 
@@ -432,11 +571,11 @@ func (o *OverworldState) Draw(g *Game, screen *ebiten.Image) {
 	o.tileMap.Draw(&g.Rend, false, 0)
 	g.DrawPlayer(&g.Player)
 
-	if g.Client.Active {
+	if g.Client.Active() {
 		g.Client.playerMap.mutex.Lock()
-		for _, player := range g.Client.playerMap.players {
-			if player.Location == g.Player.Location {
-				g.DrawPlayer(&player)
+		for _, remote := range g.Client.playerMap.players {
+			if remote.Player.Location == g.Player.Location {
+				g.DrawPlayer(&remote.Player)
 			}
 		}
 		g.Client.playerMap.mutex.Unlock()
@@ -448,6 +587,9 @@ func (o *OverworldState) Draw(g *Game, screen *ebiten.Image) {
 
 	g.CenterRendererOnPlayer()
 	g.Rend.Display(screen)
+	drawNameplates(g, screen)
+	drawConnectionState(g, screen)
+	g.Chat.Draw(screen)
 
 	if DrawDebugInfo {
 		x, y, z := g.Player.Char.X, g.Player.Char.Y, g.Player.Char.Z
@@ -466,6 +608,44 @@ cam.y: %f`,
 	g.Dialog.Draw(screen)
 }
 
+// drawNameplates writes the names of remote players above their heads
+func drawNameplates(g *Game, screen *ebiten.Image) {
+	if !g.Client.Active() {
+		return
+	}
+
+	cam := &g.Rend.Cam
+	g.Client.playerMap.mutex.Lock()
+	for _, remote := range g.Client.playerMap.players {
+		p := &remote.Player
+		if p.Location != g.Player.Location || p.Name == "" {
+			continue
+		}
+
+		w := font.MeasureString(nameplateFont, p.Name).Ceil()
+		x := (p.Char.Gx + constants.TileSize / 2 - cam.X) * cam.Scale - float64(w) / 2
+		y := (p.Char.Gy + NpcOffsetY + p.Char.OffsetY - cam.Y) * cam.Scale
+		text.Draw(screen, p.Name, nameplateFont, int(x) + 1, int(y) + 1, chatShadowClr)
+		text.Draw(screen, p.Name, nameplateFont, int(x), int(y), mapChatClr)
+	}
+	g.Client.playerMap.mutex.Unlock()
+}
+
+func drawConnectionState(g *Game, screen *ebiten.Image) {
+	var str string
+	switch g.Client.State() {
+		case Connecting:
+			str = "Connecting..."
+		case Reconnecting:
+			str = "Connection lost, reconnecting..."
+		default:
+			return
+	}
+
+	const charWidth = 6
+	ebitenutil.DebugPrintAt(screen, str, constants.DisplaySizeX - len(str) * charWidth - 4, 4)
+}
+
 //TODO: Remove usage of DisplaySizex, DisplaySizeY
 func (g *Game) CenterRendererOnPlayer() {
 	g.Rend.LookAt(
@@ -0,0 +1,91 @@
+package pok
+
+import (
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+var pauseItems = []string{"Resume", "Save", "Options", "Quit to title"}
+
+// PauseState is the menu opened with escape, drawn over the state it paused
+type PauseState struct {
+	menu Menu
+	confirm Menu
+	confirming bool	// if quitting without saving is waiting to be confirmed
+	message string
+}
+
+func NewPauseState() *PauseState {
+	return &PauseState{
+		menu: Menu{Items: pauseItems},
+		confirm: Menu{Items: []string{"No", "Yes"}},
+	}
+}
+
+func (p *PauseState) GetInputs(g *Game) error {
+	if p.confirming {
+		if pressedBack() {
+			p.confirming = false
+			return nil
+		}
+		switch p.confirm.Update() {
+			case 0:
+				p.confirming = false
+			case 1:
+				g.QuitToTitle()
+		}
+		return nil
+	}
+
+	if pressedBack() {
+		g.States.Pop()
+		return nil
+	}
+
+	chosen := p.menu.Update()
+	if chosen >= 0 {
+		p.message = ""
+	}
+	switch chosen {
+		case 0:
+			g.States.Pop()
+		case 1:
+			if err := g.Save(); err != nil {
+				p.message = "Could not save: " + err.Error()
+			} else if g.Online {
+				p.message = "Game saved, the server keeps the world"
+			} else {
+				p.message = "Game saved"
+			}
+		case 2:
+			g.States.Push(NewOptionsState())
+		case 3:
+			if !g.Unsaved() {
+				g.QuitToTitle()
+				return nil
+			}
+			p.confirming = true
+			p.confirm.Selected = 0
+	}
+	return nil
+}
+
+func (p *PauseState) Update(g *Game) error {
+	return nil
+}
+
+func (p *PauseState) DrawsUnder() bool {
+	return true
+}
+
+func (p *PauseState) Draw(g *Game, screen *ebiten.Image) {
+	w, h := p.menu.Size()
+	p.menu.Draw(screen, screen.Bounds().Dx() - w - 4, 4)
+
+	if p.confirming {
+		drawMenuText(screen, "Quit without saving?", 8, 20)
+		cw, _ := p.confirm.Size()
+		p.confirm.Draw(screen, screen.Bounds().Dx() - cw - 4, 4 + h + 4)
+	} else if p.message != "" {
+		drawMenuText(screen, p.message, 8, 20)
+	}
+}
@@ -2,14 +2,46 @@ package pok
 
 import (
 	"github.com/atemmel/pok/pkg/constants"
+	"github.com/atemmel/pok/pkg/debug"
+	"github.com/atemmel/pok/pkg/protocol"
+	"github.com/atemmel/pok/pkg/textures"
 	"github.com/hajimehoshi/ebiten/v2"
 )
 
 type Player struct {
 	Id int
+	Name string
 	Char Character
 	Connected bool
 	Location string
+	Sheet int	// index into spriteSets
+
+	sprinting bool
+}
+
+// spriteSet holds one sheet per movement mode for a playable character
+type spriteSet struct {
+	name string
+	walking *ebiten.Image
+	running *ebiten.Image
+	biking *ebiten.Image
+	surfing *ebiten.Image
+}
+
+var spriteSets []spriteSet
+
+func loadSpriteSet(name, walking, running, biking, surfing string) spriteSet {
+	var err error
+	set := spriteSet{name: name}
+	set.walking, err = textures.LoadWithError(constants.CharacterImagesDir + walking)
+	debug.Assert(err)
+	set.running, err = textures.LoadWithError(constants.CharacterImagesDir + running)
+	debug.Assert(err)
+	set.biking, err = textures.LoadWithError(constants.CharacterImagesDir + biking)
+	debug.Assert(err)
+	set.surfing, err = textures.LoadWithError(constants.CharacterImagesDir + surfing)
+	debug.Assert(err)
+	return set
 }
 
 const hmAnimFramesPerStep = 8
@@ -17,16 +49,7 @@ const totalHMAnimSteps = 4
 
 func (player *Player) Update(g *Game) {
 	stepDone := player.Char.Update(g)
-
-	if player.Char.isBiking {
-		activePlayerImg = playerBikingImg
-	} else if player.Char.isSurfing {
-		activePlayerImg = playerSurfingImg
-	} else if player.Char.isWalking && player.Char.velocity > WalkVelocity {
-		activePlayerImg = playerRunningImg
-	} else {
-		activePlayerImg = playerImg
-	}
+	player.sprinting = player.Char.isRunning || holdingSprint()
 
 	if stepDone {
 		if selectedHm == Surf {
@@ -37,11 +60,87 @@ func (player *Player) Update(g *Game) {
 		player.Char.isWalking = false
 		if i := g.Ows.tileMap.HasExitAt(player.Char.X, player.Char.Y, player.Char.Z); i > -1 {
 			if g.Ows.tileMap.Exits[i].Target != "" {
-				img := ebiten.NewImage(constants.DisplaySizeX, constants.DisplaySizeY)
-				g.As.Draw(g, img)
-				g.As = NewTransitionState(img, constants.TileMapDir + g.Ows.tileMap.Exits[i].Target, g.Ows.tileMap.Exits[i].Id)
+				g.States.Push(NewTransitionState(constants.TileMapDir + g.Ows.tileMap.Exits[i].Target, g.Ows.tileMap.Exits[i].Id))
 				g.Audio.PlayDoor()
 			}
 		}
 	}
 }
+
+// State packs the parts of the player that other clients need to see
+func (player *Player) State() protocol.PlayerState {
+	return protocol.PlayerState{
+		Id: player.Id,
+		Location: player.Location,
+		X: player.Char.X,
+		Y: player.Char.Y,
+		Z: player.Char.Z,
+		Gx: player.Char.Gx,
+		Gy: player.Char.Gy,
+		OffsetY: player.Char.OffsetY,
+		Avatar: protocol.Avatar{
+			Mode: player.mode(),
+			Facing: uint8(player.Char.dir),
+			Frame: uint8(player.Char.Tx / (constants.TileSize * 2)),
+			Sheet: uint8(player.Sheet),
+			Jumping: player.Char.isJumping,
+			Sprinting: player.sprinting,
+		},
+	}
+}
+
+func (player *Player) ApplyState(state *protocol.PlayerState) {
+	player.Id = state.Id
+	player.Connected = true
+	player.Location = state.Location
+	player.Char.X = state.X
+	player.Char.Y = state.Y
+	player.Char.Z = state.Z
+	player.Char.Gx = state.Gx
+	player.Char.Gy = state.Gy
+	player.Char.OffsetY = state.OffsetY
+
+	avatar := &state.Avatar
+	player.Sheet = int(avatar.Sheet)
+	player.sprinting = avatar.Sprinting
+	player.Char.isJumping = avatar.Jumping
+	player.Char.isBiking = avatar.Mode == protocol.Biking
+	player.Char.isSurfing = avatar.Mode == protocol.Surfing
+	player.Char.isWalking = avatar.Mode == protocol.Running
+	if player.Char.isWalking {
+		player.Char.velocity = RunVelocity
+	} else {
+		player.Char.velocity = WalkVelocity
+	}
+	player.Char.SetDirection(Direction(avatar.Facing))
+	player.Char.Tx = int(avatar.Frame) * constants.TileSize * 2
+}
+
+func (player *Player) mode() protocol.MovementMode {
+	if player.Char.isBiking {
+		return protocol.Biking
+	} else if player.Char.isSurfing {
+		return protocol.Surfing
+	} else if player.Char.isWalking && player.Char.velocity > WalkVelocity {
+		return protocol.Running
+	}
+	return protocol.Walking
+}
+
+// sprite returns the sheet matching what the player is currently doing
+func (player *Player) sprite() *ebiten.Image {
+	set := &spriteSets[0]
+	if player.Sheet >= 0 && player.Sheet < len(spriteSets) {
+		set = &spriteSets[player.Sheet]
+	}
+
+	switch player.mode() {
+		case protocol.Biking:
+			return set.biking
+		case protocol.Surfing:
+			return set.surfing
+		case protocol.Running:
+			return set.running
+	}
+	return set.walking
+}
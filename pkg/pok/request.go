@@ -0,0 +1,141 @@
+package pok
+
+import (
+	"fmt"
+	"github.com/atemmel/pok/pkg/dialog"
+	"github.com/atemmel/pok/pkg/protocol"
+)
+
+// Indexed by protocol.Interaction
+var interactionNames = []string{
+	"trade",
+	"battle",
+	"wave",
+}
+
+func interactionName(interaction protocol.Interaction) string {
+	if int(interaction) < len(interactionNames) {
+		return interactionNames[interaction]
+	}
+	return "play"
+}
+
+// tryInteractPlayer lets the player choose what to ask of another player
+func (o *OverworldState) tryInteractPlayer(x, y, z int, g *Game) bool {
+	if !g.Client.Active() {
+		return false
+	}
+	id, ok := g.Client.PlayerAt(g.Player.Location, x, y, z)
+	if !ok {
+		return false
+	}
+
+	o.asking = id
+	o.collector = dialog.MakeDialogTreeCollector(&dialog.DialogTree{
+		&dialog.ChoiceDialogNode{
+			Dialog: "What to do with " + g.Client.Name(id) + "?",
+			Choices: []string{"Trade", "Battle", "Wave", "Cancel"},
+			Results: []*int{dialog.Link(1), dialog.Link(2), dialog.Link(3), nil},
+		},
+		&dialog.EffectDialogNode{
+			Effect: "trade",
+			Next: nil,
+		},
+		&dialog.EffectDialogNode{
+			Effect: "battle",
+			Next: nil,
+		},
+		&dialog.EffectDialogNode{
+			Effect: "wave",
+			Next: nil,
+		},
+	})
+
+	g.Dialog.PeekCollector(&o.collector)
+	return true
+}
+
+// applyRequestEffect sends what was chosen in a request dialog. Returns
+// false if the effect has nothing to do with requests.
+func (o *OverworldState) applyRequestEffect(g *Game, effect string) bool {
+	switch effect {
+		case "trade":
+			g.Client.SendRequest(o.asking, protocol.TradeInteraction)
+		case "battle":
+			g.Client.SendRequest(o.asking, protocol.BattleInteraction)
+		case "wave":
+			g.Client.SendRequest(o.asking, protocol.WaveInteraction)
+		case "accept":
+			g.Client.AnswerRequest(o.incoming, true)
+		case "decline":
+			g.Client.AnswerRequest(o.incoming, false)
+		default:
+			return false
+	}
+	return true
+}
+
+// showRequest presents the next change to a request, asking for an answer
+// if another player asked us
+func (o *OverworldState) showRequest(g *Game) {
+	req, ok := g.Client.PopRequest()
+	if !ok {
+		return
+	}
+
+	if req.Status == protocol.RequestAsked && req.To == g.Client.Id() {
+		o.incoming = req
+		o.collector = dialog.MakeDialogTreeCollector(&dialog.DialogTree{
+			&dialog.ChoiceDialogNode{
+				Dialog: g.Client.Name(req.From) + " wants to " + interactionName(req.Interaction) + ".",
+				Choices: []string{"Accept", "Decline"},
+				Results: []*int{dialog.Link(1), dialog.Link(2)},
+			},
+			&dialog.EffectDialogNode{
+				Effect: "accept",
+				Next: nil,
+			},
+			&dialog.EffectDialogNode{
+				Effect: "decline",
+				Next: nil,
+			},
+		})
+	} else {
+		o.collector = dialog.MakeDialogTreeCollector(&dialog.DialogTree{
+			&dialog.DialogNode{
+				Dialog: requestText(g, &req),
+				Next: nil,
+			},
+		})
+	}
+
+	g.Dialog.PeekCollector(&o.collector)
+}
+
+// requestText describes where a request stands, from our point of view
+func requestText(g *Game, req *protocol.Request) string {
+	asked := req.From == g.Client.Id()
+	other := g.Client.Name(req.To)
+	if !asked {
+		other = g.Client.Name(req.From)
+	}
+
+	switch req.Status {
+		case protocol.RequestAsked:
+			return "Waiting for " + other + " to answer..."
+		case protocol.RequestAccepted:
+			if req.Interaction == protocol.WaveInteraction {
+				return "You and " + other + " waved at each other!"
+			}
+			return fmt.Sprintf("You and %s are ready to %s!", other, interactionName(req.Interaction))
+		case protocol.RequestDeclined:
+			if asked {
+				return other + " declined."
+			}
+			return "You declined."
+		case protocol.RequestUnavailable:
+			return other + " can not answer right now."
+		default:
+			return "The request to " + interactionName(req.Interaction) + " was called off."
+	}
+}
@@ -0,0 +1,187 @@
+package pok
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/atemmel/pok/pkg/protocol"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+// SaveVersion is bumped whenever the layout of a save changes
+const SaveVersion = 2
+
+// Where the saves are, a variable so that tests can point it elsewhere
+var SaveDir = ConfigDir + "saves/"
+
+var ErrNoSave = errors.New("no save in this slot")
+
+// SaveData is everything about a play session that survives quitting
+type SaveData struct {
+	Version int
+	SavedAt time.Time
+	Name string	// since version 2
+	Sheet int	// since version 2
+	Secret string	// the password of the player online, since version 2
+	Location string
+	X, Y, Z int
+	Facing Direction
+	Mode protocol.MovementMode
+	Clock float64	// seconds since midnight
+	ClockRate float64	// world seconds per real second, 0 if the clock followed the local time
+	Worlds map[string][]protocol.WorldEvent	// by location, the changes to each map that outlast the session, made offline
+}
+
+// newSecret makes up a password for a new player, which is kept in the save
+// so that nobody else can play as it online
+func newSecret() string {
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(secret)
+}
+
+// newGuestName makes up a name for a player that was not given one
+func newGuestName() string {
+	return "guest_" + newSecret()[:8]
+}
+
+func SavePath(slot int) string {
+	return fmt.Sprintf("%ssave%d.json", SaveDir, slot)
+}
+
+// ReadSave reads the save in a slot, returning ErrNoSave if there is none
+func ReadSave(slot int) (*SaveData, error) {
+	data, err := ioutil.ReadFile(SavePath(slot))
+	if os.IsNotExist(err) {
+		return nil, ErrNoSave
+	} else if err != nil {
+		return nil, err
+	}
+
+	save := &SaveData{}
+	if err := json.Unmarshal(data, save); err != nil {
+		return nil, err
+	}
+	if save.Version < 1 || save.Version > SaveVersion {
+		return nil, fmt.Errorf("save has version %d, expected at most %d", save.Version, SaveVersion)
+	}
+	return save, nil
+}
+
+// WriteSave writes a save to a slot. The old save is only replaced once
+// the new one has been written in full, so a crash midway loses nothing.
+func WriteSave(slot int, save *SaveData) error {
+	save.Version = SaveVersion
+	data, err := json.MarshalIndent(save, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	path := SavePath(slot)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path) + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// snapshot captures the play session as it is now
+func (g *Game) snapshot(now time.Time) *SaveData {
+	c := &g.Player.Char
+	save := &SaveData{
+		SavedAt: now,
+		Name: g.Player.Name,
+		Sheet: g.Player.Sheet,
+		Secret: g.secret,
+		Location: g.Player.Location,
+		X: c.X,
+		Y: c.Y,
+		Z: c.Z,
+		Facing: c.dir,
+		Mode: g.Player.mode(),
+	}
+	if seconds, rate, ok := worldClock.Seconds(now); ok {
+		save.Clock, save.ClockRate = seconds, rate
+	}
+	g.remember()
+	save.Worlds = g.World.Saved()
+	return save
+}
+
+// Save writes the play session to the slot of the game. Online, the world
+// belongs to the server, which keeps it. So only what was done to the maps
+// offline is saved.
+func (g *Game) Save() error {
+	if g.Player.Location == "" {
+		// Nothing has been loaded, so there is nothing to save
+		return nil
+	}
+
+	save := g.snapshot(time.Now())
+	if err := WriteSave(g.Slot, save); err != nil {
+		return err
+	}
+	g.lastSave = save
+	return nil
+}
+
+// Unsaved returns whether anything would be lost by quitting now. The
+// passing of time alone does not count.
+func (g *Game) Unsaved() bool {
+	if g.Player.Location == "" {
+		return false
+	}
+	if g.lastSave == nil {
+		return true
+	}
+
+	now := *g.snapshot(time.Time{})
+	last := *g.lastSave
+	now.Clock, now.ClockRate = 0, 0
+	last.SavedAt, last.Clock, last.ClockRate = time.Time{}, 0, 0
+	last.Version = 0
+	if len(now.Worlds) == 0 && len(last.Worlds) == 0 {
+		now.Worlds, last.Worlds = nil, nil
+	}
+	return !reflect.DeepEqual(now, last)
+}
+
+// Continue picks up a play session where a save left off
+func (g *Game) Continue(save *SaveData) {
+	g.Player.Name = save.Name
+	g.Player.Sheet = save.Sheet
+	g.secret = save.Secret
+	if save.Name == "" || save.Secret == "" {
+		// Saved before players had names of their own
+		g.Player.Name = newGuestName()
+		g.secret = newSecret()
+	}
+	g.World.Load(save.Worlds)
+	g.placePlayer(save.Location, save.X, save.Y, save.Z, save.Facing, save.Mode)
+	if save.ClockRate > 0 {
+		// A server will set it straight if there is one
+		worldClock.Sync(save.Clock, save.ClockRate, time.Now())
+	}
+	g.lastSave = save
+}
@@ -0,0 +1,146 @@
+package pok
+
+import (
+	"fmt"
+	"github.com/atemmel/pok/pkg/protocol"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// useTempSaveDir points SaveDir to a new directory, returning a function
+// that puts it back
+func useTempSaveDir(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "saves")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := SaveDir
+	SaveDir = dir + "/"
+	return func() {
+		SaveDir = old
+		os.RemoveAll(dir)
+	}
+}
+
+func TestReadSave(t *testing.T) {
+	defer useTempSaveDir(t)()
+
+	type readSaveTest struct {
+		In string	// as found in the slot, nothing if empty
+		Want *SaveData
+		Err bool
+	}
+
+	tests := []readSaveTest{
+		{"", nil, true},
+		{`{"Location": "a.json"}`, nil, true},
+		{`{"Version": 0, "Location": "a.json"}`, nil, true},
+		{fmt.Sprintf(`{"Version": %d, "Location": "a.json"}`, SaveVersion + 1), nil, true},
+		{
+			`{"Version": 1, "Location": "a.json", "X": 1, "Y": 2}`,
+			&SaveData{Version: 1, Location: "a.json", X: 1, Y: 2},
+			false,
+		},
+		{
+			`{"Version": 2, "Name": "Red", "Sheet": 1, "Location": "a.json", "Worlds": {"b.json": [{"Event": 1, "X": 3}]}}`,
+			&SaveData{
+				Version: 2,
+				Name: "Red",
+				Sheet: 1,
+				Location: "a.json",
+				Worlds: map[string][]protocol.WorldEvent{
+					"b.json": {{Event: 1, X: 3}},
+				},
+			},
+			false,
+		},
+	}
+
+	for i, test := range tests {
+		if test.In != "" {
+			if err := ioutil.WriteFile(SavePath(i), []byte(test.In), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		output, err := ReadSave(i)
+		if (err != nil) != test.Err {
+			t.Errorf("Error %v not expected for %s", err, test.In)
+		}
+		if !reflect.DeepEqual(output, test.Want) {
+			t.Errorf("Output %+v not equal to %+v", output, test.Want)
+		}
+	}
+}
+
+func TestWriteSave(t *testing.T) {
+	defer useTempSaveDir(t)()
+
+	want := &SaveData{
+		SavedAt: time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC),
+		Name: "Red",
+		Sheet: 1,
+		Secret: "6f1c0e4a",
+		Location: "a.json",
+		X: 4,
+		Y: 5,
+		Z: 1,
+		Facing: Left,
+		Mode: protocol.Surfing,
+		Clock: 3600,
+		ClockRate: 60,
+		Worlds: map[string][]protocol.WorldEvent{
+			"a.json": {{Event: protocol.TreeCut, X: 1, Y: 2}},
+		},
+	}
+	if err := WriteSave(0, want); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := ReadSave(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(output, want) {
+		t.Errorf("Output %+v not equal to %+v", output, want)
+	}
+}
+
+func TestUnsaved(t *testing.T) {
+	defer useTempSaveDir(t)()
+
+	g := &Game{World: NewWorldCache()}
+	if g.Unsaved() {
+		t.Errorf("Nothing loaded, but unsaved")
+	}
+
+	g.Player.Location = "a.json"
+	if !g.Unsaved() {
+		t.Errorf("Never saved, but not unsaved")
+	}
+
+	if err := g.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if g.Unsaved() {
+		t.Errorf("Just saved, but unsaved")
+	}
+
+	g.Player.Char.X++
+	if !g.Unsaved() {
+		t.Errorf("Moved since saving, but not unsaved")
+	}
+
+	save, err := ReadSave(g.Slot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Player.Char.X--
+	g.lastSave = save
+	if g.Unsaved() {
+		t.Errorf("Just as saved, but unsaved")
+	}
+}
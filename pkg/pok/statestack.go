@@ -0,0 +1,75 @@
+package pok
+
+import (
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// Overlay is implemented by states that are drawn on top of the states
+// under them, such as menus and transitions
+type Overlay interface {
+	DrawsUnder() bool
+}
+
+func drawsUnder(state GameState) bool {
+	overlay, ok := state.(Overlay)
+	return ok && overlay.DrawsUnder()
+}
+
+// StateStack holds the states of the game. Only the state on top gets
+// inputs and updates, and it is drawn over the states under it that are
+// still visible.
+type StateStack struct {
+	states []GameState
+}
+
+func (s *StateStack) Push(state GameState) {
+	s.states = append(s.states, state)
+}
+
+// Pop removes the state on top, returning it, or nil if there are none
+func (s *StateStack) Pop() GameState {
+	if len(s.states) == 0 {
+		return nil
+	}
+	top := s.states[len(s.states) - 1]
+	s.states[len(s.states) - 1] = nil
+	s.states = s.states[:len(s.states) - 1]
+	return top
+}
+
+// Replace swaps the state on top for another
+func (s *StateStack) Replace(state GameState) {
+	s.Pop()
+	s.Push(state)
+}
+
+// Top returns the state on top, or nil if there are none
+func (s *StateStack) Top() GameState {
+	if len(s.states) == 0 {
+		return nil
+	}
+	return s.states[len(s.states) - 1]
+}
+
+func (s *StateStack) Len() int {
+	return len(s.states)
+}
+
+// visible returns the index of the lowest state that is drawn
+func (s *StateStack) visible() int {
+	i := len(s.states) - 1
+	for i > 0 && drawsUnder(s.states[i]) {
+		i--
+	}
+	return i
+}
+
+// Draw draws every visible state, from the bottom up
+func (s *StateStack) Draw(g *Game, screen *ebiten.Image) {
+	if len(s.states) == 0 {
+		return
+	}
+	for _, state := range s.states[s.visible():] {
+		state.Draw(g, screen)
+	}
+}
@@ -0,0 +1,86 @@
+package pok
+
+import (
+	"github.com/hajimehoshi/ebiten/v2"
+	"reflect"
+	"testing"
+)
+
+type testState struct {
+	name string
+	overlay bool
+	drawn *[]string
+}
+
+func (t *testState) GetInputs(g *Game) error {
+	return nil
+}
+
+func (t *testState) Update(g *Game) error {
+	return nil
+}
+
+func (t *testState) Draw(g *Game, screen *ebiten.Image) {
+	*t.drawn = append(*t.drawn, t.name)
+}
+
+func (t *testState) DrawsUnder() bool {
+	return t.overlay
+}
+
+func TestStateStackDraw(t *testing.T) {
+	drawn := []string{}
+	world := &testState{"world", false, &drawn}
+	pause := &testState{"pause", true, &drawn}
+	options := &testState{"options", true, &drawn}
+	battle := &testState{"battle", false, &drawn}
+
+	type stackTest struct {
+		In []GameState
+		Want []string
+	}
+
+	tests := []stackTest{
+		{[]GameState{world}, []string{"world"}},
+		{[]GameState{world, pause, options}, []string{"world", "pause", "options"}},
+		{[]GameState{world, battle, pause}, []string{"battle", "pause"}},
+		{[]GameState{pause}, []string{"pause"}},
+		{[]GameState{}, []string{}},
+	}
+
+	for _, test := range tests {
+		drawn = drawn[:0]
+		s := StateStack{}
+		for _, state := range test.In {
+			s.Push(state)
+		}
+		s.Draw(nil, nil)
+		if !reflect.DeepEqual(drawn, test.Want) {
+			t.Errorf("Output %+v not equal to %+v", drawn, test.Want)
+		}
+	}
+}
+
+func TestStateStackPopReplace(t *testing.T) {
+	drawn := []string{}
+	world := &testState{"world", false, &drawn}
+	pause := &testState{"pause", true, &drawn}
+	options := &testState{"options", true, &drawn}
+
+	s := StateStack{}
+	s.Push(world)
+	s.Push(pause)
+	s.Replace(options)
+	if s.Top() != options || s.Len() != 2 {
+		t.Errorf("Output %+v not equal to %+v", s.Top(), options)
+	}
+	if popped := s.Pop(); popped != options {
+		t.Errorf("Output %+v not equal to %+v", popped, options)
+	}
+	if popped := s.Pop(); popped != world {
+		t.Errorf("Output %+v not equal to %+v", popped, world)
+	}
+	if popped := s.Pop(); popped != nil {
+		t.Errorf("Output %+v not equal to %+v", popped, nil)
+	}
+}
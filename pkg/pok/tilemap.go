@@ -3,6 +3,7 @@ package pok
 import(
 	"encoding/json"
 	"github.com/atemmel/pok/pkg/constants"
+	"github.com/atemmel/pok/pkg/protocol"
 	"github.com/atemmel/pok/pkg/textures"
 	"github.com/hajimehoshi/ebiten/v2"
 	"io/ioutil"
@@ -45,6 +46,9 @@ type Boulder struct {
 	frames int
 	velocity float64
 	dir Direction
+	forced bool	// pushed by another player, so it moves no matter what
+	originX int	// where the map file placed it
+	originY int
 }
 
 func (b *Boulder) Update(g *Game) {
@@ -61,6 +65,7 @@ func (b *Boulder) Update(g *Game) {
 	if b.frames * int(b.velocity) >= constants.TileSize {
 		b.frames = 0
 		b.dir = Static
+		b.forced = false
 	}
 }
 
@@ -83,13 +88,25 @@ func (b *Boulder) tryStep(g *Game) {
 	nx, ny := b.X, b.Y
 	b.X, b.Y = ox, oy
 
-	if g.TileIsOccupied(nx, ny, b.Z - 1) {
+	if !b.forced && g.TileIsOccupied(nx, ny, b.Z - 1) {
 		b.dir = Static
 		return
 	}
 
 	b.X, b.Y = nx, ny
 
+	if !b.forced {
+		g.Client.SendWorldEvent(protocol.WorldEvent{
+			Location: g.Player.Location,
+			Event: protocol.BoulderMoved,
+			X: ox,
+			Y: oy,
+			Z: b.Z - 1,
+			ToX: nx,
+			ToY: ny,
+		})
+	}
+
 	b.velocity = WalkVelocity
 	b.step()
 }
@@ -375,6 +392,9 @@ func (t *TileMap) OpenFile(path string) error {
 			return err
 		}
 	}
+	// Start from scratch, as decoding into the slices of the previous map
+	// would keep what has been done to its objects
+	*t = TileMap{}
 	err = json.Unmarshal(data, t)
 	if err != nil {
 		return err
@@ -402,6 +422,8 @@ func (t *TileMap) init() {
 		boulder := &t.Boulders[i]
 		boulder.gX = float64(boulder.X * constants.TileSize)
 		boulder.gY = float64(boulder.Y * constants.TileSize)
+		boulder.originX = boulder.X
+		boulder.originY = boulder.Y
 	}
 }
 
@@ -822,11 +844,103 @@ func (t *TileMap) AddBoulderAt(x, y, z int) {
 		Z: z,
 		gX: float64(x * constants.TileSize),
 		gY: float64(y * constants.TileSize),
+		originX: x,
+		originY: y,
 	}
 	
 	t.Boulders = append(t.Boulders, boulder)
 }
 
+// ApplyWorldEvent makes the same change to the map as another player did.
+// Coordinates are the same as for GetRockAt, GetBoulderIndexAt and so on.
+func (t *TileMap) ApplyWorldEvent(ev *protocol.WorldEvent) {
+	switch ev.Event {
+		case protocol.RockSmashed:
+			if i := t.GetRockAt(ev.X, ev.Y, ev.Z); i != -1 {
+				t.Rocks[i].smashed = true
+			}
+		case protocol.TreeCut:
+			if i := t.GetCuttableTreeAt(ev.X, ev.Y, ev.Z); i != -1 {
+				t.CuttableTrees[i].cut = true
+			}
+		case protocol.BoulderMoved:
+			i := t.GetBoulderIndexAt(ev.X, ev.Y, ev.Z)
+			if i == -1 {
+				return
+			}
+
+			boulder := &t.Boulders[i]
+			switch {
+				case ev.ToY < ev.Y:
+					boulder.dir = Up
+				case ev.ToY > ev.Y:
+					boulder.dir = Down
+				case ev.ToX < ev.X:
+					boulder.dir = Left
+				case ev.ToX > ev.X:
+					boulder.dir = Right
+			}
+			boulder.forced = true
+	}
+}
+
+// ApplyWorldState brings a freshly loaded map up to date. Unlike
+// ApplyWorldEvent, boulders are placed right where they ended up.
+func (t *TileMap) ApplyWorldState(state *protocol.WorldState) {
+	// Find every boulder before moving any, since one may have been moved
+	// to where another one started
+	moved := make(map[int]*protocol.WorldEvent)
+	for i := range state.Events {
+		ev := &state.Events[i]
+		if ev.Event != protocol.BoulderMoved {
+			t.ApplyWorldEvent(ev)
+		} else if j := t.GetBoulderIndexAt(ev.X, ev.Y, ev.Z); j != -1 {
+			moved[j] = ev
+		}
+	}
+
+	for i, ev := range moved {
+		boulder := &t.Boulders[i]
+		boulder.X, boulder.Y = ev.ToX, ev.ToY
+		boulder.gX = float64(ev.ToX * constants.TileSize)
+		boulder.gY = float64(ev.ToY * constants.TileSize)
+		boulder.dir = Static
+		boulder.frames = 0
+	}
+}
+
+// WorldEvents describes what has been done to the map since it was loaded,
+// in a form that ApplyWorldState can bring a freshly loaded map up to date
+// with
+func (t *TileMap) WorldEvents() []protocol.WorldEvent {
+	events := make([]protocol.WorldEvent, 0)
+	for _, rock := range t.Rocks {
+		if rock.smashed {
+			events = append(events, protocol.WorldEvent{Event: protocol.RockSmashed, X: rock.X, Y: rock.Y, Z: rock.Z - 1})
+		}
+	}
+
+	for _, tree := range t.CuttableTrees {
+		if tree.cut {
+			events = append(events, protocol.WorldEvent{Event: protocol.TreeCut, X: tree.X, Y: tree.Y, Z: tree.Z - 1})
+		}
+	}
+
+	for _, boulder := range t.Boulders {
+		if boulder.X != boulder.originX || boulder.Y != boulder.originY {
+			events = append(events, protocol.WorldEvent{
+				Event: protocol.BoulderMoved,
+				X: boulder.originX,
+				Y: boulder.originY,
+				Z: boulder.Z - 1,
+				ToX: boulder.X,
+				ToY: boulder.Y,
+			})
+		}
+	}
+	return events
+}
+
 func (t *TileMap) GetNpcInfoIndexAt(x, y, z int) int {
 	for i := range t.NpcInfo {
 		npc := &t.NpcInfo[i]
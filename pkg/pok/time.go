@@ -1,6 +1,7 @@
 package pok
 
 import(
+	"sync"
 	"time"
 	"math"
 )
@@ -69,8 +70,58 @@ var availableEffects = []TimeRenderEffect{
 	},
 }
 
-func GetTimeOfDay() TimeOfDay {
+// Clock is the time of day in the world. It follows the local time until a
+// server tells it otherwise.
+type Clock struct {
+	synced bool
+	seconds float64	// since midnight, at syncedAt
+	rate float64	// world seconds per real second
+	syncedAt time.Time
+	mutex sync.Mutex
+}
+
+var worldClock Clock
+
+// Sync sets the clock to the given number of seconds since midnight
+func (c *Clock) Sync(seconds, rate float64, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.synced = true
+	c.seconds = seconds
+	c.rate = rate
+	c.syncedAt = now
+}
+
+// Seconds returns the number of seconds since midnight and how fast the
+// clock runs, or false if it follows the local time
+func (c *Clock) Seconds(now time.Time) (float64, float64, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.synced {
+		return 0, 0, false
+	}
+	seconds := c.seconds + now.Sub(c.syncedAt).Seconds() * c.rate
+	return math.Mod(seconds, 24 * 60 * 60), c.rate, true
+}
+
+func (c *Clock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	now := time.Now()
+	if !c.synced {
+		return now
+	}
+
+	seconds := c.seconds + now.Sub(c.syncedAt).Seconds() * c.rate
+	seconds = math.Mod(seconds, 24 * 60 * 60)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return midnight.Add(time.Duration(seconds * float64(time.Second)))
+}
+
+func GetTimeOfDay() TimeOfDay {
+	now := worldClock.Now()
 	hour := now.Hour()
 
 	if 4 <= hour && hour < 10 {
@@ -83,7 +134,7 @@ func GetTimeOfDay() TimeOfDay {
 }
 
 func GetActiveEffect() (float64, float64, float64) {
-	now := time.Now()
+	now := worldClock.Now()
 	hour := now.Hour()
 	minute := now.Minute()
 	second := now.Second()
@@ -0,0 +1,141 @@
+package pok
+
+import (
+	"github.com/atemmel/pok/pkg/constants"
+	"github.com/atemmel/pok/pkg/protocol"
+	"github.com/hajimehoshi/ebiten/v2"
+	"image"
+	"image/color"
+	"log"
+)
+
+var titleBgClr = color.RGBA{40, 40, 48, 255}
+
+// TitleState is what the game opens with, to start a new game, continue
+// the saved one or change the options
+type TitleState struct {
+	menu Menu
+	save *SaveData	// to continue, nil if there is none
+	naming Typewriter
+	name string	// chosen for the new game, empty until then
+	sheets Menu
+	message string
+}
+
+func NewTitleState(save *SaveData) *TitleState {
+	t := &TitleState{save: save}
+	t.menu.Items = []string{"New Game"}
+	if save != nil {
+		t.menu.Items = append(t.menu.Items, "Continue")
+		// Most of the time, that is what the player wants
+		t.menu.Selected = 1
+	}
+	t.menu.Items = append(t.menu.Items, "Options", "Quit")
+	for _, set := range spriteSets {
+		t.sheets.Items = append(t.sheets.Items, set.name)
+	}
+	return t
+}
+
+// ShowTitle goes to the title screen, which offers to continue the save in
+// the slot of the game if there is one
+func (g *Game) ShowTitle() {
+	save, err := ReadSave(g.Slot)
+	if err != nil && err != ErrNoSave {
+		log.Println("Could not read save:", err)
+	}
+	g.States.Replace(NewTitleState(save))
+}
+
+func (t *TitleState) GetInputs(g *Game) error {
+	if t.naming.Active {
+		t.naming.HandleInputs()
+		if len(t.naming.Input) > protocol.MaxNameLength {
+			t.naming.Input = t.naming.Input[:protocol.MaxNameLength]
+		}
+		return nil
+	}
+
+	if t.name != "" {
+		if pressedBack() {
+			t.name = ""
+			return nil
+		}
+		if chosen := t.sheets.Update(); chosen >= 0 {
+			conf, err := ReadClientConfig()
+			if err != nil {
+				log.Println("Could not read client config, starting on the default map:", err)
+				conf.setDefaults()
+			}
+			g.NewGame(t.name, chosen, constants.TileMapDir + conf.StartMap, conf.StartEntry)
+		}
+		return nil
+	}
+
+	chosen := t.menu.Update()
+	if chosen < 0 {
+		return nil
+	}
+	t.message = ""
+	switch t.menu.Items[chosen] {
+		case "New Game":
+			t.naming.Start("Your name: ", func(name string) {
+				if name == "" {
+					return
+				}
+				if !protocol.ValidName(name) {
+					t.message = protocol.ErrInvalidName.Error()
+					return
+				}
+				t.name = name
+			})
+		case "Continue":
+			g.Continue(t.save)
+			g.start()
+		case "Options":
+			g.States.Push(NewOptionsState())
+		case "Quit":
+			return ErrQuit
+	}
+	return nil
+}
+
+func (t *TitleState) Update(g *Game) error {
+	return nil
+}
+
+func (t *TitleState) Draw(g *Game, screen *ebiten.Image) {
+	screen.Fill(titleBgClr)
+	drawMenuText(screen, "pok", constants.DisplaySizeX / 2 - 12, 48)
+
+	if t.naming.Active {
+		drawMenuText(screen, t.naming.GetDisplayString() + "_", 32, constants.DisplaySizeY / 2)
+		return
+	}
+
+	if t.name != "" {
+		drawMenuText(screen, "Which one are you, " + t.name + "?", 32, 96)
+		t.sheets.Draw(screen, 32, 112)
+		t.drawSheet(screen, t.sheets.Selected)
+		return
+	}
+
+	w, _ := t.menu.Size()
+	t.menu.Draw(screen, (constants.DisplaySizeX - w) / 2, 96)
+	if t.message != "" {
+		drawMenuText(screen, t.message, 32, constants.DisplaySizeY - 32)
+	}
+}
+
+// drawSheet shows a sprite set, facing the player
+func (t *TitleState) drawSheet(screen *ebiten.Image, i int) {
+	if i < 0 || i >= len(spriteSets) {
+		return
+	}
+	size := constants.TileSize * 2
+	sprite := spriteSets[i].walking.SubImage(image.Rect(0, 0, size, size)).(*ebiten.Image)
+	opt := &ebiten.DrawImageOptions{}
+	opt.GeoM.Scale(3, 3)
+	opt.GeoM.Translate(float64(constants.DisplaySizeX / 2), 96)
+	screen.DrawImage(sprite, opt)
+}
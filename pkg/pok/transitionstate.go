@@ -1,6 +1,7 @@
 package pok
 
 import (
+	"github.com/atemmel/pok/pkg/constants"
 	"github.com/hajimehoshi/ebiten/v2"
 	"image/color"
 )
@@ -11,22 +12,19 @@ type TransitionState struct {
 	file string
 	exitId int
 	magnitude int
-	fadeFrom *ebiten.Image
 	currentFade *ebiten.Image
 }
 
 const nTransitionTicks = 10
 
-func NewTransitionState(src *ebiten.Image, file string, exitId int) *TransitionState {
-	img := ebiten.NewImageFromImage(src)
-	fade := ebiten.NewImage(img.Bounds().Max.X, img.Bounds().Max.Y)
+func NewTransitionState(file string, exitId int) *TransitionState {
+	fade := ebiten.NewImage(constants.DisplaySizeX, constants.DisplaySizeY)
 	fade.Fill(color.RGBA{0, 0, 0, 0})
 	return &TransitionState{
 		0,
 		file,
 		exitId,
 		1,
-		img,
 		fade,
 	}
 }
@@ -40,11 +38,10 @@ func (t *TransitionState) Update(g *Game) error {
 	if t.Ticks > nTransitionTicks {
 		g.Load(t.file, t.exitId)
 		g.Ows.Update(g)
-		g.Ows.Draw(g, t.fadeFrom)
 		t.magnitude = -1;
 		return nil
 	} else if t.Ticks == 0 {
-		g.As = &g.Ows
+		g.States.Pop()
 		return nil
 	}
 	scale := float64(t.Ticks) / float64(nTransitionTicks)
@@ -52,7 +49,11 @@ func (t *TransitionState) Update(g *Game) error {
 	return nil
 }
 
+// The overworld is not updated while fading, so it stands still under the fade
+func (t *TransitionState) DrawsUnder() bool {
+	return true
+}
+
 func (t *TransitionState) Draw(g *Game, screen *ebiten.Image) {
-	screen.DrawImage(t.fadeFrom, &ebiten.DrawImageOptions{})
 	screen.DrawImage(t.currentFade, &ebiten.DrawImageOptions{})
 }
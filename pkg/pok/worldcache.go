@@ -0,0 +1,107 @@
+package pok
+
+import (
+	"github.com/atemmel/pok/pkg/protocol"
+)
+
+// Persistence says how long a change to a map lasts
+type Persistence int
+
+const (
+	// Lasts until the game is closed or the player rests
+	SessionPersistence Persistence = iota
+	// Lasts until the player rests, and is saved until then
+	RestPersistence
+	// Lasts for good, and is always saved
+	ForeverPersistence
+)
+
+// WorldRules says how long each kind of change to a map lasts. Boulders are
+// saved where they were pushed, and go back when the player rests, so that a
+// puzzle that can no longer be solved can always be reset. Using strength on
+// a map lasts for the session.
+var WorldRules = map[protocol.EventKind]Persistence{
+	protocol.RockSmashed: RestPersistence,
+	protocol.TreeCut: ForeverPersistence,
+	protocol.BoulderMoved: RestPersistence,
+}
+
+// mapWorld is what has been done to a single map
+type mapWorld struct {
+	events []protocol.WorldEvent
+	strength bool	// if strength has been used on the map
+}
+
+// WorldCache remembers what has been done to the maps the player has left,
+// so that they are just as the player left them when it comes back. It is
+// only used offline, as the server is in charge of the world online. What
+// it holds is still kept in the save, for the next time the game is offline.
+type WorldCache struct {
+	maps map[string]*mapWorld	// by path
+}
+
+func NewWorldCache() WorldCache {
+	return WorldCache{make(map[string]*mapWorld)}
+}
+
+// Remember stores what has been done to a map that is about to be unloaded
+func (w *WorldCache) Remember(path string, t *TileMap, strength bool) {
+	events := t.WorldEvents()
+	if len(events) == 0 && !strength {
+		delete(w.maps, path)
+		return
+	}
+	w.maps[path] = &mapWorld{events, strength}
+}
+
+// Restore brings a freshly loaded map up to date, returning whether strength
+// has been used on it
+func (w *WorldCache) Restore(path string, t *TileMap) bool {
+	world, ok := w.maps[path]
+	if !ok {
+		return false
+	}
+	if len(world.events) > 0 {
+		t.ApplyWorldState(&protocol.WorldState{Location: path, Events: world.events})
+	}
+	return world.strength
+}
+
+// Rest brings back everything that does not last forever
+func (w *WorldCache) Rest() {
+	for path, world := range w.maps {
+		kept := world.events[:0]
+		for _, ev := range world.events {
+			if WorldRules[ev.Event] > RestPersistence {
+				kept = append(kept, ev)
+			}
+		}
+		world.events = kept
+		world.strength = false
+		if len(world.events) == 0 {
+			delete(w.maps, path)
+		}
+	}
+}
+
+// Saved returns the changes that outlast the session, by path
+func (w *WorldCache) Saved() map[string][]protocol.WorldEvent {
+	saved := make(map[string][]protocol.WorldEvent)
+	for path, world := range w.maps {
+		for _, ev := range world.events {
+			if WorldRules[ev.Event] > SessionPersistence {
+				saved[path] = append(saved[path], ev)
+			}
+		}
+	}
+	return saved
+}
+
+// Load replaces everything the cache knows with the changes of a save
+func (w *WorldCache) Load(saved map[string][]protocol.WorldEvent) {
+	w.maps = make(map[string]*mapWorld, len(saved))
+	for path, events := range saved {
+		// Copied, as resting filters the events in place
+		w.maps[path] = &mapWorld{events: append([]protocol.WorldEvent(nil), events...)}
+	}
+}
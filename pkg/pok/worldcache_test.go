@@ -0,0 +1,35 @@
+package pok
+
+import (
+	"github.com/atemmel/pok/pkg/protocol"
+	"reflect"
+	"testing"
+)
+
+func TestWorldCacheRest(t *testing.T) {
+	smashed := protocol.WorldEvent{Event: protocol.RockSmashed, X: 1, Y: 2}
+	cut := protocol.WorldEvent{Event: protocol.TreeCut, X: 3, Y: 4}
+	moved := protocol.WorldEvent{Event: protocol.BoulderMoved, X: 5, Y: 6, ToX: 5, ToY: 7}
+
+	w := NewWorldCache()
+	w.Load(map[string][]protocol.WorldEvent{
+		"route": {smashed, cut, moved},
+		"cave": {smashed},
+	})
+
+	want := map[string][]protocol.WorldEvent{
+		"route": {smashed, cut, moved},
+		"cave": {smashed},
+	}
+	if output := w.Saved(); !reflect.DeepEqual(output, want) {
+		t.Errorf("Output %+v not equal to %+v", output, want)
+	}
+
+	w.Rest()
+	want = map[string][]protocol.WorldEvent{
+		"route": {cut},
+	}
+	if output := w.Saved(); !reflect.DeepEqual(output, want) {
+		t.Errorf("Output %+v not equal to %+v", output, want)
+	}
+}
@@ -0,0 +1,56 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Datagrams carry player states over udp, next to the tcp connection. Each
+// holds a single frame, prefixed with the key handed out in Welcome and a
+// sequence number, so that states which arrive late can be dropped.
+const datagramHeaderSize = 8 + 4
+
+// MaxDatagramSize is the size of the largest possible datagram
+const MaxDatagramSize = datagramHeaderSize + headerSize + MaxPayloadSize
+
+func EncodeDatagram(key uint64, seq uint32, msg Message) ([]byte, error) {
+	frame, err := Encode(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	datagram := make([]byte, datagramHeaderSize, datagramHeaderSize + len(frame))
+	binary.BigEndian.PutUint64(datagram, key)
+	binary.BigEndian.PutUint32(datagram[8:], seq)
+	return append(datagram, frame...), nil
+}
+
+// DecodeDatagram returns the key, sequence number and message of a datagram.
+// Datagrams must hold exactly one frame.
+func DecodeDatagram(datagram []byte) (uint64, uint32, Message, error) {
+	if len(datagram) < datagramHeaderSize {
+		return 0, 0, nil, fmt.Errorf("%w: datagram of %d bytes", ErrMalformed, len(datagram))
+	}
+
+	key := binary.BigEndian.Uint64(datagram)
+	seq := binary.BigEndian.Uint32(datagram[8:])
+
+	r := bytes.NewReader(datagram[datagramHeaderSize:])
+	msg, err := ReadMessage(r)
+	if err == nil && r.Len() != 0 {
+		err = fmt.Errorf("%w: %d bytes after the frame of a datagram", ErrMalformed, r.Len())
+	} else if err != nil && !errors.Is(err, ErrMalformed) {
+		err = fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	return key, seq, msg, nil
+}
+
+// Newer reports whether seq was sent after last, allowing for wrap-around
+func Newer(seq, last uint32) bool {
+	return int32(seq - last) > 0
+}
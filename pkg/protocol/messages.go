@@ -0,0 +1,568 @@
+package protocol
+
+// Hello is the first message a client sends after connecting. Token is
+// empty unless the client is trying to resume an earlier session. Room is
+// empty to join the default room of the server. Spawn asks to be moved to
+// where the server has the player, for clients that do not keep track of
+// that themselves. The server checks moves from there either way.
+type Hello struct {
+	Version uint16
+	Token string
+	Name string
+	Password string
+	Room string
+	Spawn bool
+}
+
+// Welcome is the servers reply to an accepted Hello. Token can be used to
+// resume the session if the connection drops. Name is spelled the way the
+// account was registered. If UdpPort is not 0, player states may be sent as
+// datagrams to that port, using UdpKey.
+type Welcome struct {
+	Id int
+	Token string
+	Name string
+	UdpPort int
+	UdpKey uint64
+	Spawn Spawn
+}
+
+// Spawn is where a player left off the last time it played. Location is
+// empty for new players, and when a session is resumed.
+type Spawn struct {
+	Location string
+	X, Y, Z int
+	Facing uint8
+	Mode MovementMode
+}
+
+// Reject is sent instead of Welcome, right before the server hangs up
+type Reject struct {
+	Reason string
+}
+
+type Join struct {
+	Id int
+	Name string
+}
+
+type Leave struct {
+	Id int
+}
+
+// EnterMap and LeaveMap tell a client that another player became visible
+// or stopped being visible, which happens whenever either of them changes map
+type EnterMap struct {
+	Id int
+	Location string
+}
+
+type LeaveMap struct {
+	Id int
+	Location string
+}
+
+type MovementMode uint8
+
+const (
+	Walking MovementMode = iota
+	Running
+	Biking
+	Surfing
+)
+
+// Avatar is everything besides the position that is needed to draw a player
+type Avatar struct {
+	Mode MovementMode
+	Facing uint8
+	Frame uint8	// column in the sprite sheet
+	Sheet uint8
+	Jumping bool
+	Sprinting bool
+}
+
+type PlayerState struct {
+	Id int
+	Location string
+	X, Y, Z int
+	Gx, Gy float64
+	OffsetY float64
+	Avatar Avatar
+}
+
+// Heartbeat keeps an idle connection from timing out
+type Heartbeat struct {
+}
+
+// Announcement is a message from the server operator to everyone
+type Announcement struct {
+	Text string
+}
+
+// Kick is sent right before the server closes a connection for good, the
+// client should not try to reconnect
+type Kick struct {
+	Reason string
+}
+
+// Correction is sent when the server refuses a move. The client should put
+// its player back where the server last saw it.
+type Correction struct {
+	Location string
+	X, Y, Z int
+}
+
+// Clock tells clients what time of day it is in the world, in seconds since
+// midnight, and how many world seconds pass per real second
+type Clock struct {
+	Seconds float64
+	Rate float64
+}
+
+type WeatherType uint8
+
+const (
+	ClearWeather WeatherType = iota
+	HailWeather
+	RainWeather
+)
+
+// Weather is sent when a player enters a map, and whenever the weather on
+// the map changes
+type Weather struct {
+	Location string
+	Weather WeatherType
+}
+
+type Interaction uint8
+
+const (
+	TradeInteraction Interaction = iota
+	BattleInteraction
+	WaveInteraction
+)
+
+type RequestStatus uint8
+
+const (
+	RequestAsked RequestStatus = iota
+	RequestAccepted
+	RequestDeclined
+	// RequestUnavailable is sent to the asker alone, when the other player
+	// is too far away or busy with another request
+	RequestUnavailable
+	// RequestExpired is sent when a request went unanswered for too long,
+	// or when either player left
+	RequestExpired
+)
+
+// Request asks another player to do something together. Clients send it
+// with RequestAsked and To set to the player they face, or with an answer
+// and To set to the player that asked. The server fills in From, and
+// relays every change of status to both players, with From always being
+// the player that asked.
+type Request struct {
+	From, To int
+	Interaction Interaction
+	Status RequestStatus
+}
+
+type ChatScope uint8
+
+const (
+	MapChat ChatScope = iota
+	GlobalChat
+	// SystemChat is used by the server to talk to a single player
+	SystemChat
+)
+
+// Chat is sent by clients without an id, which the server fills in before
+// passing it on
+type Chat struct {
+	Id int
+	Scope ChatScope
+	Text string
+}
+
+type EventKind uint8
+
+const (
+	RockSmashed EventKind = iota + 1
+	TreeCut
+	BoulderMoved
+)
+
+// WorldEvent is a change to an object on a map. X, Y and Z is where the
+// object was, ToX and ToY is where a BoulderMoved boulder ended up.
+type WorldEvent struct {
+	Id int
+	Location string
+	Event EventKind
+	X, Y, Z int
+	ToX, ToY int
+}
+
+// WorldState lists every change that has been made to a map, and is sent to
+// players as they enter it. The Id and Location of each event is left out
+// on the wire.
+type WorldState struct {
+	Location string
+	Events []WorldEvent
+}
+
+// MaxWorldStateEvents is the most events that fit in a single WorldState
+const MaxWorldStateEvents = (MaxPayloadSize - 2 - 256) / worldStateEventSize
+
+const worldStateEventSize = 1 + 5 * 4
+
+func (m *Hello) Kind() Kind {
+	return HelloKind
+}
+
+func (m *Hello) encode(e *encoder) {
+	e.u16(m.Version)
+	e.str(m.Token)
+	e.str(m.Name)
+	e.str(m.Password)
+	e.str(m.Room)
+	e.boolean(m.Spawn)
+}
+
+func (m *Hello) decode(d *decoder) {
+	m.Version = d.u16()
+	m.Token = d.str()
+	m.Name = d.str()
+	m.Password = d.str()
+	m.Room = d.str()
+	m.Spawn = d.boolean()
+}
+
+func (m *Welcome) Kind() Kind {
+	return WelcomeKind
+}
+
+func (m *Welcome) encode(e *encoder) {
+	e.i32(m.Id)
+	e.str(m.Token)
+	e.str(m.Name)
+	e.i32(m.UdpPort)
+	e.u64(m.UdpKey)
+	m.Spawn.encode(e)
+}
+
+func (m *Welcome) decode(d *decoder) {
+	m.Id = d.i32()
+	m.Token = d.str()
+	m.Name = d.str()
+	m.UdpPort = d.i32()
+	m.UdpKey = d.u64()
+	m.Spawn.decode(d)
+}
+
+func (s *Spawn) encode(e *encoder) {
+	e.str(s.Location)
+	e.i32(s.X)
+	e.i32(s.Y)
+	e.i32(s.Z)
+	e.u8(s.Facing)
+	e.u8(uint8(s.Mode))
+}
+
+func (s *Spawn) decode(d *decoder) {
+	s.Location = d.str()
+	s.X = d.i32()
+	s.Y = d.i32()
+	s.Z = d.i32()
+	s.Facing = d.u8()
+	s.Mode = MovementMode(d.u8())
+}
+
+func (m *Reject) Kind() Kind {
+	return RejectKind
+}
+
+func (m *Reject) encode(e *encoder) {
+	e.str(m.Reason)
+}
+
+func (m *Reject) decode(d *decoder) {
+	m.Reason = d.str()
+}
+
+func (m *Join) Kind() Kind {
+	return JoinKind
+}
+
+func (m *Join) encode(e *encoder) {
+	e.i32(m.Id)
+	e.str(m.Name)
+}
+
+func (m *Join) decode(d *decoder) {
+	m.Id = d.i32()
+	m.Name = d.str()
+}
+
+func (m *Leave) Kind() Kind {
+	return LeaveKind
+}
+
+func (m *Leave) encode(e *encoder) {
+	e.i32(m.Id)
+}
+
+func (m *Leave) decode(d *decoder) {
+	m.Id = d.i32()
+}
+
+func (m *PlayerState) Kind() Kind {
+	return PlayerStateKind
+}
+
+func (m *PlayerState) encode(e *encoder) {
+	e.i32(m.Id)
+	e.str(m.Location)
+	e.i32(m.X)
+	e.i32(m.Y)
+	e.i32(m.Z)
+	e.f64(m.Gx)
+	e.f64(m.Gy)
+	e.f64(m.OffsetY)
+	m.Avatar.encode(e)
+}
+
+func (m *PlayerState) decode(d *decoder) {
+	m.Id = d.i32()
+	m.Location = d.str()
+	m.X = d.i32()
+	m.Y = d.i32()
+	m.Z = d.i32()
+	m.Gx = d.f64()
+	m.Gy = d.f64()
+	m.OffsetY = d.f64()
+	m.Avatar.decode(d)
+}
+
+func (a *Avatar) encode(e *encoder) {
+	e.u8(uint8(a.Mode))
+	e.u8(a.Facing)
+	e.u8(a.Frame)
+	e.u8(a.Sheet)
+	e.boolean(a.Jumping)
+	e.boolean(a.Sprinting)
+}
+
+func (a *Avatar) decode(d *decoder) {
+	a.Mode = MovementMode(d.u8())
+	a.Facing = d.u8()
+	a.Frame = d.u8()
+	a.Sheet = d.u8()
+	a.Jumping = d.boolean()
+	a.Sprinting = d.boolean()
+}
+
+func (m *WorldEvent) Kind() Kind {
+	return WorldEventKind
+}
+
+func (m *WorldEvent) encode(e *encoder) {
+	e.i32(m.Id)
+	e.str(m.Location)
+	e.u8(uint8(m.Event))
+	e.i32(m.X)
+	e.i32(m.Y)
+	e.i32(m.Z)
+	e.i32(m.ToX)
+	e.i32(m.ToY)
+}
+
+func (m *WorldEvent) decode(d *decoder) {
+	m.Id = d.i32()
+	m.Location = d.str()
+	m.Event = EventKind(d.u8())
+	m.X = d.i32()
+	m.Y = d.i32()
+	m.Z = d.i32()
+	m.ToX = d.i32()
+	m.ToY = d.i32()
+}
+
+func (m *WorldState) Kind() Kind {
+	return WorldStateKind
+}
+
+func (m *WorldState) encode(e *encoder) {
+	e.str(m.Location)
+	e.u16(uint16(len(m.Events)))
+	for i := range m.Events {
+		ev := &m.Events[i]
+		e.u8(uint8(ev.Event))
+		e.i32(ev.X)
+		e.i32(ev.Y)
+		e.i32(ev.Z)
+		e.i32(ev.ToX)
+		e.i32(ev.ToY)
+	}
+}
+
+func (m *WorldState) decode(d *decoder) {
+	m.Location = d.str()
+	n := int(d.u16())
+	if n * worldStateEventSize > len(d.buf) {
+		d.err = errShortPayload
+		return
+	}
+
+	m.Events = make([]WorldEvent, n)
+	for i := range m.Events {
+		ev := &m.Events[i]
+		ev.Event = EventKind(d.u8())
+		ev.X = d.i32()
+		ev.Y = d.i32()
+		ev.Z = d.i32()
+		ev.ToX = d.i32()
+		ev.ToY = d.i32()
+	}
+}
+
+func (m *EnterMap) Kind() Kind {
+	return EnterMapKind
+}
+
+func (m *EnterMap) encode(e *encoder) {
+	e.i32(m.Id)
+	e.str(m.Location)
+}
+
+func (m *EnterMap) decode(d *decoder) {
+	m.Id = d.i32()
+	m.Location = d.str()
+}
+
+func (m *LeaveMap) Kind() Kind {
+	return LeaveMapKind
+}
+
+func (m *LeaveMap) encode(e *encoder) {
+	e.i32(m.Id)
+	e.str(m.Location)
+}
+
+func (m *LeaveMap) decode(d *decoder) {
+	m.Id = d.i32()
+	m.Location = d.str()
+}
+
+func (m *Heartbeat) Kind() Kind {
+	return HeartbeatKind
+}
+
+func (m *Heartbeat) encode(e *encoder) {
+}
+
+func (m *Heartbeat) decode(d *decoder) {
+}
+
+func (m *Announcement) Kind() Kind {
+	return AnnouncementKind
+}
+
+func (m *Announcement) encode(e *encoder) {
+	e.str(m.Text)
+}
+
+func (m *Announcement) decode(d *decoder) {
+	m.Text = d.str()
+}
+
+func (m *Kick) Kind() Kind {
+	return KickKind
+}
+
+func (m *Kick) encode(e *encoder) {
+	e.str(m.Reason)
+}
+
+func (m *Kick) decode(d *decoder) {
+	m.Reason = d.str()
+}
+
+func (m *Chat) Kind() Kind {
+	return ChatKind
+}
+
+func (m *Chat) encode(e *encoder) {
+	e.i32(m.Id)
+	e.u8(uint8(m.Scope))
+	e.str(m.Text)
+}
+
+func (m *Chat) decode(d *decoder) {
+	m.Id = d.i32()
+	m.Scope = ChatScope(d.u8())
+	m.Text = d.str()
+}
+
+func (m *Correction) Kind() Kind {
+	return CorrectionKind
+}
+
+func (m *Correction) encode(e *encoder) {
+	e.str(m.Location)
+	e.i32(m.X)
+	e.i32(m.Y)
+	e.i32(m.Z)
+}
+
+func (m *Correction) decode(d *decoder) {
+	m.Location = d.str()
+	m.X = d.i32()
+	m.Y = d.i32()
+	m.Z = d.i32()
+}
+
+func (m *Clock) Kind() Kind {
+	return ClockKind
+}
+
+func (m *Clock) encode(e *encoder) {
+	e.f64(m.Seconds)
+	e.f64(m.Rate)
+}
+
+func (m *Clock) decode(d *decoder) {
+	m.Seconds = d.f64()
+	m.Rate = d.f64()
+}
+
+func (m *Weather) Kind() Kind {
+	return WeatherKind
+}
+
+func (m *Weather) encode(e *encoder) {
+	e.str(m.Location)
+	e.u8(uint8(m.Weather))
+}
+
+func (m *Weather) decode(d *decoder) {
+	m.Location = d.str()
+	m.Weather = WeatherType(d.u8())
+}
+
+func (m *Request) Kind() Kind {
+	return RequestKind
+}
+
+func (m *Request) encode(e *encoder) {
+	e.i32(m.From)
+	e.i32(m.To)
+	e.u8(uint8(m.Interaction))
+	e.u8(uint8(m.Status))
+}
+
+func (m *Request) decode(d *decoder) {
+	m.From = d.i32()
+	m.To = d.i32()
+	m.Interaction = Interaction(d.u8())
+	m.Status = RequestStatus(d.u8())
+}
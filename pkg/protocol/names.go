@@ -0,0 +1,25 @@
+package protocol
+
+import (
+	"errors"
+)
+
+const (
+	MinNameLength = 3
+	MaxNameLength = 16
+)
+
+var ErrInvalidName = errors.New("Names must be 3 to 16 letters, digits or underscores")
+
+// ValidName returns whether a player may go by a name
+func ValidName(name string) bool {
+	if len(name) < MinNameLength || len(name) > MaxNameLength {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
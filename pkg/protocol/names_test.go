@@ -0,0 +1,27 @@
+package protocol
+
+import (
+	"testing"
+)
+
+func TestValidName(t *testing.T) {
+	type validNameTest struct {
+		In string
+		Want bool
+	}
+
+	tests := []validNameTest{
+		{"Red", true},
+		{"ash_99", true},
+		{"ab", false},
+		{"abcdefghijklmnopq", false},
+		{"two words", false},
+		{"Åsa", false},
+	}
+
+	for _, test := range tests {
+		if output := ValidName(test.In); output != test.Want {
+			t.Errorf("ValidName(%q) gave %t, expected %t", test.In, output, test.Want)
+		}
+	}
+}
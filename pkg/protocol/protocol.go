@@ -0,0 +1,278 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Version is bumped whenever the layout of a message changes
+const Version = 16
+
+// MaxPayloadSize is the largest payload a single frame may carry
+const MaxPayloadSize = 4096
+
+// DefaultWebSocketPath is where servers accept websocket connections, unless
+// configured otherwise
+const DefaultWebSocketPath = "/pok"
+
+// Every frame starts with the payload length (uint32) followed by the kind
+const headerSize = 4 + 1
+
+type Kind uint8
+
+const (
+	HelloKind Kind = iota + 1
+	WelcomeKind
+	RejectKind
+	JoinKind
+	LeaveKind
+	PlayerStateKind
+	WorldEventKind
+	EnterMapKind
+	LeaveMapKind
+	HeartbeatKind
+	AnnouncementKind
+	KickKind
+	ChatKind
+	WorldStateKind
+	CorrectionKind
+	ClockKind
+	WeatherKind
+	RequestKind
+)
+
+// ErrMalformed is returned when a frame was read in full but its payload
+// could not be decoded. The stream is still in sync when this happens.
+var ErrMalformed = errors.New("malformed message")
+
+var ErrPayloadTooLarge = errors.New("message payload too large")
+
+type Message interface {
+	Kind() Kind
+	encode(e *encoder)
+	decode(d *decoder)
+}
+
+func newMessage(kind Kind) Message {
+	switch kind {
+		case HelloKind:
+			return &Hello{}
+		case WelcomeKind:
+			return &Welcome{}
+		case RejectKind:
+			return &Reject{}
+		case JoinKind:
+			return &Join{}
+		case LeaveKind:
+			return &Leave{}
+		case PlayerStateKind:
+			return &PlayerState{}
+		case WorldEventKind:
+			return &WorldEvent{}
+		case EnterMapKind:
+			return &EnterMap{}
+		case LeaveMapKind:
+			return &LeaveMap{}
+		case HeartbeatKind:
+			return &Heartbeat{}
+		case AnnouncementKind:
+			return &Announcement{}
+		case KickKind:
+			return &Kick{}
+		case ChatKind:
+			return &Chat{}
+		case WorldStateKind:
+			return &WorldState{}
+		case CorrectionKind:
+			return &Correction{}
+		case ClockKind:
+			return &Clock{}
+		case WeatherKind:
+			return &Weather{}
+		case RequestKind:
+			return &Request{}
+	}
+	return nil
+}
+
+// Encode returns msg as a complete frame, ready to be written to a stream
+func Encode(msg Message) ([]byte, error) {
+	e := &encoder{make([]byte, headerSize, 64)}
+	msg.encode(e)
+
+	size := len(e.buf) - headerSize
+	if size > MaxPayloadSize {
+		return nil, ErrPayloadTooLarge
+	}
+
+	binary.BigEndian.PutUint32(e.buf, uint32(size))
+	e.buf[4] = byte(msg.Kind())
+	return e.buf, nil
+}
+
+func WriteMessage(w io.Writer, msg Message) error {
+	frame, err := Encode(msg)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(frame)
+	return err
+}
+
+func ReadMessage(r io.Reader) (Message, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[:4])
+	if size > MaxPayloadSize {
+		return nil, ErrPayloadTooLarge
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	kind := Kind(header[4])
+	msg := newMessage(kind)
+	if msg == nil {
+		return nil, fmt.Errorf("%w: unknown kind %d", ErrMalformed, kind)
+	}
+
+	d := &decoder{payload, nil}
+	msg.decode(d)
+	if d.err != nil {
+		return nil, fmt.Errorf("%w: kind %d: %v", ErrMalformed, kind, d.err)
+	}
+	if len(d.buf) != 0 {
+		return nil, fmt.Errorf("%w: kind %d has %d trailing bytes", ErrMalformed, kind, len(d.buf))
+	}
+
+	return msg, nil
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) u8(v uint8) {
+	e.buf = append(e.buf, v)
+}
+
+func (e *encoder) u16(v uint16) {
+	e.buf = append(e.buf, byte(v >> 8), byte(v))
+}
+
+func (e *encoder) boolean(v bool) {
+	if v {
+		e.u8(1)
+	} else {
+		e.u8(0)
+	}
+}
+
+func (e *encoder) i32(v int) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(int32(v)))
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) u64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) f64(v float64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], math.Float64bits(v))
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) str(v string) {
+	if len(v) > math.MaxUint16 {
+		v = v[:math.MaxUint16]
+	}
+	e.u16(uint16(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+// decoder remembers the first error it runs into, so that messages can
+// decode all of their fields and only check for failure once
+type decoder struct {
+	buf []byte
+	err error
+}
+
+var errShortPayload = errors.New("payload too short")
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.buf) < n {
+		d.err = errShortPayload
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) u8() uint8 {
+	b := d.take(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) boolean() bool {
+	return d.u8() != 0
+}
+
+func (d *decoder) u16() uint16 {
+	b := d.take(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (d *decoder) i32() int {
+	b := d.take(4)
+	if b == nil {
+		return 0
+	}
+	return int(int32(binary.BigEndian.Uint32(b)))
+}
+
+func (d *decoder) u64() uint64 {
+	b := d.take(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (d *decoder) f64() float64 {
+	b := d.take(8)
+	if b == nil {
+		return 0
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(b))
+}
+
+func (d *decoder) str() string {
+	n := int(d.u16())
+	b := d.take(n)
+	if b == nil {
+		return ""
+	}
+	return string(b)
+}
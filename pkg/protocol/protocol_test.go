@@ -0,0 +1,147 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	tests := []Message{
+		&Hello{Version, "", "Red", "pikachu", "", false},
+		&Hello{Version, "6f1c0e4a", "Red", "pikachu", "arena", true},
+		&Welcome{7, "6f1c0e4a", "Red", 0, 0, Spawn{}},
+		&Welcome{7, "6f1c0e4a", "Red", 6567, 0xdeadbeefcafe, Spawn{"resources/tilemaps/beach", 4, 5, 1, 2, Surfing}},
+		&Reject{"protocol version mismatch"},
+		&Join{3, "Blue"},
+		&Leave{-1},
+		&PlayerState{2, "resources/tilemaps/beach", 4, 5, 1, 64.5, 80, -3.25, Avatar{Surfing, 3, 2, 1, false, true}},
+		&WorldEvent{2, "resources/tilemaps/beach", RockSmashed, 10, 11, 1, 0, 0},
+		&WorldEvent{2, "resources/tilemaps/cave", BoulderMoved, 3, 4, 0, 3, 5},
+		&WorldState{"resources/tilemaps/cave", []WorldEvent{
+			{Event: TreeCut, X: 1, Y: 2, Z: 1},
+			{Event: BoulderMoved, X: 3, Y: 4, ToX: 8, ToY: 4},
+		}},
+		&WorldState{"resources/tilemaps/beach", []WorldEvent{}},
+		&Correction{"resources/tilemaps/beach", 3, 4, 0},
+		&Clock{43200.5, 24},
+		&Weather{"resources/tilemaps/beach", RainWeather},
+		&Request{3, 5, BattleInteraction, RequestDeclined},
+		&EnterMap{4, "resources/tilemaps/cave"},
+		&LeaveMap{4, "resources/tilemaps/beach"},
+		&Heartbeat{},
+		&Announcement{"Server restarts in 5 minutes"},
+		&Kick{"Flooding"},
+		&Chat{3, GlobalChat, "hello there"},
+	}
+
+	buf := &bytes.Buffer{}
+	for _, msg := range tests {
+		if err := WriteMessage(buf, msg); err != nil {
+			t.Fatalf("Could not write %T: %v", msg, err)
+		}
+	}
+
+	for _, want := range tests {
+		got, err := ReadMessage(buf)
+		if err != nil {
+			t.Fatalf("Could not read %T: %v", want, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Output %+v not equal to %+v", got, want)
+		}
+	}
+
+	if buf.Len() != 0 {
+		t.Errorf("%d bytes left unread", buf.Len())
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	type malformedTest struct {
+		In []byte
+		Want error
+	}
+
+	tests := []malformedTest{
+		{[]byte{0, 0, 0, 0, 200}, ErrMalformed},
+		{[]byte{0, 0, 0, 1, byte(WelcomeKind), 0}, ErrMalformed},
+		{[]byte{0, 0, 0, 5, byte(HelloKind), 0, 1, 0, 0, 0}, ErrMalformed},
+		{[]byte{0, 0, 0, 4, byte(WorldStateKind), 0, 0, 3, 232}, ErrMalformed},
+		{[]byte{0, 1, 0, 0, byte(HelloKind)}, ErrPayloadTooLarge},
+	}
+
+	for _, test := range tests {
+		_, err := ReadMessage(bytes.NewReader(test.In))
+		if !errors.Is(err, test.Want) {
+			t.Errorf("Input %v gave error %v, expected %v", test.In, err, test.Want)
+		}
+	}
+}
+
+func TestMalformedKeepsStreamInSync(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{0, 0, 0, 2, 200, 1, 2})
+	WriteMessage(buf, &Join{5, "Blue"})
+
+	if _, err := ReadMessage(buf); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("Expected malformed message, got %v", err)
+	}
+
+	msg, err := ReadMessage(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if join, ok := msg.(*Join); !ok || join.Id != 5 {
+		t.Errorf("Expected join from 5, got %+v", msg)
+	}
+}
+
+func TestDatagram(t *testing.T) {
+	state := &PlayerState{Id: 2, Location: "resources/tilemaps/beach", X: 4, Y: 5}
+	datagram, err := EncodeDatagram(0xdeadbeefcafe, 42, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, seq, msg, err := DecodeDatagram(datagram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != 0xdeadbeefcafe || seq != 42 || !reflect.DeepEqual(msg, state) {
+		t.Errorf("Output %x, %d, %+v not equal to %x, %d, %+v", key, seq, msg, 0xdeadbeefcafe, 42, state)
+	}
+
+	tests := [][]byte{
+		datagram[:datagramHeaderSize - 1],
+		datagram[:len(datagram) - 1],
+		append(datagram, 0),
+	}
+	for _, test := range tests {
+		if _, _, _, err := DecodeDatagram(test); !errors.Is(err, ErrMalformed) {
+			t.Errorf("Datagram of %d bytes gave error %v, expected %v", len(test), err, ErrMalformed)
+		}
+	}
+}
+
+func TestNewer(t *testing.T) {
+	type newerTest struct {
+		Seq, Last uint32
+		Want bool
+	}
+
+	tests := []newerTest{
+		{2, 1, true},
+		{1, 2, false},
+		{1, 1, false},
+		{1, 0, true},
+		{0, 0xffffffff, true},
+		{0xffffffff, 0, false},
+	}
+
+	for _, test := range tests {
+		if output := Newer(test.Seq, test.Last); output != test.Want {
+			t.Errorf("Output %v not equal to %v for %d after %d", output, test.Want, test.Seq, test.Last)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package recording
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/atemmel/pok/pkg/protocol"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// A recording starts with magic, the version of the format, the protocol
+// version of the messages and when recording started. After that come the
+// entries, each made up of the time since the previous entry in
+// microseconds and the connection id (both as uvarints), a byte of flags and
+// finally the message as a frame, just as it went over the wire. Entries
+// that mark a closed connection have no frame.
+const magic = "pokrec"
+
+const headerSize = len(magic) + 2 + 2 + 8
+
+// FormatVersion is bumped whenever the layout of a recording changes
+const FormatVersion = 1
+
+const (
+	outboundFlag = 1 << iota
+	udpFlag
+	closedFlag
+)
+
+var ErrNotRecording = errors.New("not a recording")
+
+var errClosed = errors.New("recording is closed")
+
+type Direction uint8
+
+const (
+	// Inbound messages were sent by a client to the server
+	Inbound Direction = iota
+	// Outbound messages were sent by the server to a client
+	Outbound
+)
+
+func (d Direction) String() string {
+	if d == Outbound {
+		return "out"
+	}
+	return "in"
+}
+
+// Entry is a single recorded message
+type Entry struct {
+	At time.Duration	// since recording started
+	Conn uint32
+	Direction Direction
+	Udp bool	// if the message was a datagram
+	Closed bool	// if the connection was closed, in which case Message is nil
+	Message protocol.Message
+}
+
+// Recorder writes entries to a recording. It is safe to use from several
+// goroutines at once.
+type Recorder struct {
+	w *bufio.Writer
+	closer io.Closer	// nil unless the recorder owns the file
+	start time.Time
+	last time.Duration	// of the previous entry
+	err error	// the first error, after which nothing more is written
+	mutex sync.Mutex
+}
+
+// Create starts a new recording in the file at path
+func Create(path string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := NewRecorder(file, time.Now())
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	r.closer = file
+	return r, nil
+}
+
+// NewRecorder starts a new recording in w, with start as the point in time
+// that entries are timed from
+func NewRecorder(w io.Writer, start time.Time) (*Recorder, error) {
+	r := &Recorder{w: bufio.NewWriter(w), start: start}
+
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	binary.BigEndian.PutUint16(header[len(magic):], FormatVersion)
+	binary.BigEndian.PutUint16(header[len(magic) + 2:], protocol.Version)
+	binary.BigEndian.PutUint64(header[len(magic) + 4:], uint64(start.UnixNano()))
+
+	if _, err := r.w.Write(header); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Record adds an already encoded frame to the recording
+func (r *Recorder) Record(now time.Time, conn uint32, direction Direction, udp bool, frame []byte) {
+	var flags byte
+	if direction == Outbound {
+		flags |= outboundFlag
+	}
+	if udp {
+		flags |= udpFlag
+	}
+	r.write(now, conn, flags, frame)
+}
+
+// RecordClose marks that a connection was closed
+func (r *Recorder) RecordClose(now time.Time, conn uint32) {
+	r.write(now, conn, closedFlag, nil)
+}
+
+func (r *Recorder) write(now time.Time, conn uint32, flags byte, frame []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.err != nil {
+		return
+	}
+
+	// Entries are never allowed to go back in time
+	at := now.Sub(r.start)
+	if at < r.last {
+		at = r.last
+	}
+	delta := (at - r.last) / time.Microsecond
+	r.last += delta * time.Microsecond
+
+	var head [2 * binary.MaxVarintLen64 + 1]byte
+	n := binary.PutUvarint(head[:], uint64(delta))
+	n += binary.PutUvarint(head[n:], uint64(conn))
+	head[n] = flags
+
+	if _, err := r.w.Write(head[:n + 1]); err != nil {
+		r.err = err
+		return
+	}
+	if _, err := r.w.Write(frame); err != nil {
+		r.err = err
+	}
+}
+
+// RecordMessage encodes a message and adds it to the recording
+func (r *Recorder) RecordMessage(now time.Time, conn uint32, direction Direction, udp bool, msg protocol.Message) {
+	frame, err := protocol.Encode(msg)
+	if err != nil {
+		return
+	}
+	r.Record(now, conn, direction, udp, frame)
+}
+
+// Flush writes buffered entries, returning the first error the recorder
+// ran into, if any. Flushing a closed recorder does nothing.
+func (r *Recorder) Flush() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.err == errClosed {
+		return nil
+	} else if r.err != nil {
+		return r.err
+	}
+	r.err = r.w.Flush()
+	return r.err
+}
+
+// Close flushes the recording, after which nothing more is recorded
+func (r *Recorder) Close() error {
+	err := r.Flush()
+	r.mutex.Lock()
+	r.err = errClosed
+	r.mutex.Unlock()
+	if r.closer != nil {
+		if closeErr := r.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Reader reads the entries of a recording, in the order they were recorded
+type Reader struct {
+	r *bufio.Reader
+	Start time.Time	// when recording started
+	at time.Duration
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:len(magic)]) != magic {
+		return nil, ErrNotRecording
+	}
+
+	format := binary.BigEndian.Uint16(header[len(magic):])
+	if format != FormatVersion {
+		return nil, fmt.Errorf("recording has format version %d, expected %d", format, FormatVersion)
+	}
+	version := binary.BigEndian.Uint16(header[len(magic) + 2:])
+	if version != protocol.Version {
+		return nil, fmt.Errorf("recording uses protocol version %d, expected %d", version, protocol.Version)
+	}
+
+	start := time.Unix(0, int64(binary.BigEndian.Uint64(header[len(magic) + 4:])))
+	return &Reader{br, start, 0}, nil
+}
+
+// Next returns the next entry, or io.EOF once there are no more
+func (r *Reader) Next() (Entry, error) {
+	delta, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	conn, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return Entry{}, io.ErrUnexpectedEOF
+	}
+	flags, err := r.r.ReadByte()
+	if err != nil {
+		return Entry{}, io.ErrUnexpectedEOF
+	}
+
+	r.at += time.Duration(delta) * time.Microsecond
+	if flags & closedFlag != 0 {
+		return Entry{At: r.at, Conn: uint32(conn), Closed: true}, nil
+	}
+
+	msg, err := protocol.ReadMessage(r.r)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+
+	entry := Entry{r.at, uint32(conn), Inbound, flags & udpFlag != 0, false, msg}
+	if flags & outboundFlag != 0 {
+		entry.Direction = Outbound
+	}
+	return entry, nil
+}
+
+// ReadFile reads every entry of the recording at path
+func ReadFile(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r, err := NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for {
+		entry, err := r.Next()
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return entries, err
+		}
+		entries = append(entries, entry)
+	}
+}
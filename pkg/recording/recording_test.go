@@ -0,0 +1,64 @@
+package recording
+
+import (
+	"bytes"
+	"github.com/atemmel/pok/pkg/protocol"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	start := time.Now()
+	tests := []Entry{
+		{0, 1, Inbound, false, false, &protocol.Hello{Version: protocol.Version, Name: "Red"}},
+		{time.Millisecond, 1, Outbound, false, false, &protocol.Welcome{Id: 0, Token: "6f1c0e4a", Name: "Red"}},
+		{time.Second, 2, Inbound, true, false, &protocol.PlayerState{Id: 1, Location: "beach", X: 4, Y: 5}},
+		{time.Second, 2, Outbound, false, false, &protocol.Chat{Id: 1, Scope: protocol.GlobalChat, Text: "hi"}},
+		{3 * time.Second, 1, Inbound, false, true, nil},
+	}
+
+	buf := &bytes.Buffer{}
+	recorder, err := NewRecorder(buf, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range tests {
+		if entry.Closed {
+			recorder.RecordClose(start.Add(entry.At), entry.Conn)
+		} else {
+			recorder.RecordMessage(start.Add(entry.At), entry.Conn, entry.Direction, entry.Udp, entry.Message)
+		}
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reader.Start.Equal(start) {
+		t.Errorf("Start %v not equal to %v", reader.Start, start)
+	}
+
+	for _, want := range tests {
+		entry, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(entry, want) {
+			t.Errorf("Output %+v not equal to %+v", entry, want)
+		}
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Errorf("Expected EOF after the last entry, got %v", err)
+	}
+}
+
+func TestNotRecording(t *testing.T) {
+	if _, err := NewReader(bytes.NewBufferString("hello there, this is not a recording")); err != ErrNotRecording {
+		t.Errorf("Expected %v, got %v", ErrNotRecording, err)
+	}
+}
@@ -0,0 +1,203 @@
+package recording
+
+import (
+	"errors"
+	"fmt"
+	"github.com/atemmel/pok/pkg/protocol"
+	"github.com/atemmel/pok/pkg/transport"
+	"net"
+	"time"
+)
+
+// How long to wait for the server to welcome a replayed connection
+const welcomeTimeout = 5 * time.Second
+
+// DefaultPassword is what replayed connections log in with, since
+// recordings do not hold passwords
+const DefaultPassword = "replay"
+
+// Options control how a recording is played back
+type Options struct {
+	Speed float64	// 2 plays twice as fast, the original speed if 0
+	Password string	// DefaultPassword if empty
+	Network transport.Network	// transport.System if nil
+}
+
+func (opts *Options) setDefaults() {
+	if opts.Speed <= 0 {
+		opts.Speed = 1
+	}
+	if opts.Password == "" {
+		opts.Password = DefaultPassword
+	}
+	if opts.Network == nil {
+		opts.Network = transport.System
+	}
+}
+
+// clock waits for the moments that entries were recorded at, scaled by the
+// speed of playback
+type clock struct {
+	start time.Time
+	offset time.Duration	// of the first entry played
+	speed float64
+}
+
+func (c *clock) wait(at time.Duration) {
+	scaled := time.Duration(float64(at - c.offset) / c.speed)
+	time.Sleep(time.Until(c.start.Add(scaled)))
+}
+
+// replayConn is a connection to the server, standing in for one that was
+// recorded
+type replayConn struct {
+	conn net.Conn
+	welcomes chan *protocol.Welcome
+}
+
+func (c *replayConn) read() {
+	defer close(c.welcomes)
+	for {
+		msg, err := protocol.ReadMessage(c.conn)
+		if errors.Is(err, protocol.ErrMalformed) {
+			continue
+		} else if err != nil {
+			return
+		}
+		if welcome, ok := msg.(*protocol.Welcome); ok {
+			c.welcomes <- welcome
+		}
+	}
+}
+
+// ReplayToServer plays the clients of a recording back to the server at
+// addr, opening a connection for every recorded one and sending what its
+// client sent, when it sent it. Whatever the server answers is ignored,
+// except for Welcome, which is used to translate the tokens and ids of the
+// recording to those of the server, so that resumed sessions and requests
+// still line up. Datagrams are sent over tcp.
+func ReplayToServer(entries []Entry, addr string, opts Options) error {
+	opts.setDefaults()
+	if len(entries) == 0 {
+		return nil
+	}
+
+	conns := make(map[uint32]*replayConn)
+	defer func() {
+		for _, c := range conns {
+			c.conn.Close()
+		}
+	}()
+
+	tokens := make(map[string]string)
+	ids := make(map[int]int)
+	c := clock{time.Now(), entries[0].At, opts.Speed}
+
+	for _, entry := range entries {
+		c.wait(entry.At)
+		replay, open := conns[entry.Conn]
+
+		if entry.Closed {
+			if open {
+				replay.conn.Close()
+				delete(conns, entry.Conn)
+			}
+			continue
+		}
+
+		if entry.Direction == Outbound {
+			// Only a Welcome that the replayed connection is waiting for
+			// is of interest
+			old, ok := entry.Message.(*protocol.Welcome)
+			if !ok || !open {
+				continue
+			}
+			select {
+				case welcome, ok := <-replay.welcomes:
+					if !ok {
+						return fmt.Errorf("connection %d was refused by the server", entry.Conn)
+					}
+					tokens[old.Token] = welcome.Token
+					ids[old.Id] = welcome.Id
+				case <-time.After(welcomeTimeout):
+					return fmt.Errorf("connection %d was never welcomed by the server", entry.Conn)
+			}
+			continue
+		}
+
+		msg := entry.Message
+		switch m := msg.(type) {
+			case *protocol.Hello:
+				hello := *m
+				hello.Token = tokens[m.Token]
+				hello.Password = opts.Password
+				msg = &hello
+			case *protocol.Request:
+				request := *m
+				if id, ok := ids[m.To]; ok {
+					request.To = id
+				}
+				msg = &request
+		}
+
+		if !open {
+			conn, err := opts.Network.Dial(addr)
+			if err != nil {
+				return err
+			}
+			replay = &replayConn{conn, make(chan *protocol.Welcome, 1)}
+			conns[entry.Conn] = replay
+			go replay.read()
+		}
+		if err := protocol.WriteMessage(replay.conn, msg); err != nil {
+			// The server hung up, as it did during recording, or not
+			replay.conn.Close()
+			delete(conns, entry.Conn)
+		}
+	}
+	return nil
+}
+
+// ReplayToClient waits for a client to connect to listener, and plays back
+// what the server sent over a recorded connection. Anything the client
+// sends is ignored.
+func ReplayToClient(entries []Entry, id uint32, listener net.Listener, opts Options) error {
+	opts.setDefaults()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	go func() {
+		for {
+			if _, err := protocol.ReadMessage(conn); err != nil && !errors.Is(err, protocol.ErrMalformed) {
+				return
+			}
+		}
+	}()
+
+	var c *clock
+	for _, entry := range entries {
+		if entry.Conn != id {
+			continue
+		}
+		if entry.Closed {
+			break
+		}
+		if entry.Direction != Outbound {
+			continue
+		}
+
+		// The client connected just now, so start from its first message
+		if c == nil {
+			c = &clock{time.Now(), entry.At, opts.Speed}
+		}
+		c.wait(entry.At)
+		if err := protocol.WriteMessage(conn, entry.Message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
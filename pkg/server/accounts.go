@@ -0,0 +1,219 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/atemmel/pok/pkg/protocol"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	saltSize = 16
+	hashIterations = 50000
+	maxPendingLogins = 8	// hashed at once, more are turned away
+)
+
+var (
+	ErrInvalidName = protocol.ErrInvalidName
+	ErrNoPassword = errors.New("A password is required")
+	ErrWrongPassword = errors.New("Wrong name or password")
+	ErrRegistrationClosed = errors.New("This server does not accept new players")
+	ErrBusy = errors.New("Too many players are logging in, try again later")
+)
+
+type account struct {
+	Name string
+	Salt string	// hex encoded
+	Hash string	// hex encoded
+}
+
+// accountStore keeps the accounts in a JSON file, which is rewritten every
+// time a new account is registered
+type accountStore struct {
+	path string
+	closed bool	// if true, only existing accounts may log in
+	accounts map[string]*account	// by lower case name
+	pending int	// logins being hashed
+	mutex sync.Mutex
+}
+
+func loadAccounts(path string, closed bool) (*accountStore, error) {
+	store := &accountStore{
+		path: path,
+		closed: closed,
+		accounts: make(map[string]*account),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var accounts []account
+	if err := json.Unmarshal(data, &accounts); err != nil {
+		return nil, err
+	}
+
+	for i := range accounts {
+		store.accounts[strings.ToLower(accounts[i].Name)] = &accounts[i]
+	}
+	return store, nil
+}
+
+// login checks the password of an account, registering it first if it does
+// not exist. Names are case insensitive, the returned name is spelled the
+// way it was registered.
+func (s *accountStore) login(name, password string) (string, error) {
+	if !protocol.ValidName(name) {
+		return "", ErrInvalidName
+	}
+	if password == "" {
+		return "", ErrNoPassword
+	}
+
+	// Hashing is slow on purpose, so it is done without holding the mutex
+	// and only for a few logins at a time
+	s.mutex.Lock()
+	if s.pending >= maxPendingLogins {
+		s.mutex.Unlock()
+		return "", ErrBusy
+	}
+	s.pending++
+	acc, ok := s.accounts[strings.ToLower(name)]
+	closed := s.closed
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		s.pending--
+		s.mutex.Unlock()
+	}()
+
+	if !ok {
+		if closed {
+			return "", ErrRegistrationClosed
+		}
+		return s.register(name, password)
+	}
+	return acc.Name, acc.check(password)
+}
+
+// register adds an account and saves the store. If someone else registered
+// the name in the meantime, the password is checked against theirs instead.
+func (s *accountStore) register(name, password string) (string, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := strings.ToLower(name)
+	acc := &account{
+		name,
+		hex.EncodeToString(salt),
+		hex.EncodeToString(hashPassword(password, salt)),
+	}
+
+	s.mutex.Lock()
+	if existing, ok := s.accounts[key]; ok {
+		s.mutex.Unlock()
+		return existing.Name, existing.check(password)
+	}
+
+	s.accounts[key] = acc
+	err := s.save()
+	if err != nil {
+		delete(s.accounts, key)
+	}
+	s.mutex.Unlock()
+
+	if err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// check compares a password with the one the account was registered with.
+// Accounts are never changed once registered, so no lock is needed.
+func (a *account) check(password string) error {
+	salt, err := hex.DecodeString(a.Salt)
+	if err != nil {
+		return err
+	}
+	want, err := hex.DecodeString(a.Hash)
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare(hashPassword(password, salt), want) != 1 {
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+// save rewrites the file with every account. Assumes that mutex is held.
+func (s *accountStore) save() error {
+	accounts := make([]account, 0, len(s.accounts))
+	for _, acc := range s.accounts {
+		accounts = append(accounts, *acc)
+	}
+
+	data, err := json.MarshalIndent(accounts, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data)
+}
+
+// writeFileAtomic writes to a temporary file first, so that a crash halfway
+// through does not lose everything that was in the file before
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path) + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// hashPassword is PBKDF2 with HMAC-SHA256, producing a single block
+func hashPassword(password string, salt []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(password))
+	var block [4]byte
+	binary.BigEndian.PutUint32(block[:], 1)
+
+	mac.Write(salt)
+	mac.Write(block[:])
+	u := mac.Sum(nil)
+	result := make([]byte, len(u))
+	copy(result, u)
+
+	for i := 1; i < hashIterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAccountStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "accounts.json")
+
+	store, err := loadAccounts(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name, err := store.login("Red", "pikachu"); err != nil || name != "Red" {
+		t.Fatalf("Could not register: %q, %v", name, err)
+	}
+
+	// Reload to make sure that the account was saved
+	store, err = loadAccounts(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name, err := store.login("RED", "pikachu"); err != nil || name != "Red" {
+		t.Errorf("Could not log in: %q, %v", name, err)
+	}
+
+	if _, err := store.login("Red", "raichu"); err != ErrWrongPassword {
+		t.Errorf("Expected %v, got %v", ErrWrongPassword, err)
+	}
+
+	if _, err := store.login("Blue", "eevee"); err != ErrRegistrationClosed {
+		t.Errorf("Expected %v, got %v", ErrRegistrationClosed, err)
+	}
+}
+
+func TestAccountStoreSaveFailed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The directory does not exist, so nothing can be saved
+	store, err := loadAccounts(filepath.Join(dir, "missing", "accounts.json"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.login("Red", "pikachu"); err == nil {
+		t.Fatal("Expected the save to fail")
+	}
+
+	// An account that was never saved must not be taken by the next player
+	if _, ok := store.accounts["red"]; ok {
+		t.Error("Account was kept after the save failed")
+	}
+	if _, err := store.login("Red", "raichu"); err == nil || err == ErrWrongPassword {
+		t.Errorf("Expected the save to fail again, got %v", err)
+	}
+}
+
+func TestAccountStoreBusy(t *testing.T) {
+	store := &accountStore{
+		accounts: make(map[string]*account),
+		pending: maxPendingLogins,
+	}
+
+	if _, err := store.login("Red", "pikachu"); err != ErrBusy {
+		t.Errorf("Expected %v, got %v", ErrBusy, err)
+	}
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type kickRequest struct {
+	Player string	// id or name
+	Reason string
+}
+
+type broadcastRequest struct {
+	Text string
+}
+
+type weatherRequest struct {
+	Room string	// the default room if empty
+	Location string
+	Weather string	// clear, hail or rain
+}
+
+type timeRequest struct {
+	Room string	// the default room if empty
+	Time string	// HH:MM
+}
+
+// AdminHandler serves the status of the server as JSON at /status, and
+// takes JSON requests to change things under /admin/. Admin requests must
+// carry the AdminToken of the config as a bearer token, and are refused
+// if there is no token.
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.serveStatus)
+	mux.Handle("/admin/kick", s.adminOnly(s.serveKick))
+	mux.Handle("/admin/broadcast", s.adminOnly(s.serveBroadcast))
+	mux.Handle("/admin/weather", s.adminOnly(s.serveWeather))
+	mux.Handle("/admin/time", s.adminOnly(s.serveTime))
+	return mux
+}
+
+func (s *Server) listenAdmin() error {
+	listener, err := s.conf.Network.Listen(s.conf.AdminAddr)
+	if err != nil {
+		return err
+	}
+
+	admin := &http.Server{Handler: s.AdminHandler()}
+
+	s.connsMutex.Lock()
+	s.admin = admin
+	s.connsMutex.Unlock()
+
+	log.Println("Serving status on", listener.Addr())
+	go func() {
+		if err := admin.Serve(listener); err != http.ErrServerClosed {
+			log.Println("Admin listener stopped:", err)
+		}
+	}()
+	return nil
+}
+
+func (s *Server) serveStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Status())
+}
+
+// adminOnly lets POST requests with the right token through to serve,
+// after decoding their body into the request that serve expects
+func (s *Server) adminOnly(serve func(w http.ResponseWriter, r *http.Request)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if s.conf.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.conf.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Missing or wrong admin token", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		log.Println("Admin request from", r.RemoteAddr, "to", r.URL.Path)
+		serve(w, r)
+	})
+}
+
+// decode reads the body of a request, answering with an error if it could
+// not be read
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(v); err != nil {
+		http.Error(w, "Invalid request: " + err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (s *Server) serveKick(w http.ResponseWriter, r *http.Request) {
+	var req kickRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	id, ok := s.FindPlayer(req.Player)
+	if !ok || !s.Kick(id, req.reason()) {
+		http.Error(w, "No player called " + req.Player, http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *kickRequest) reason() string {
+	if r.Reason == "" {
+		return "Kicked by operator"
+	}
+	return r.Reason
+}
+
+func (s *Server) serveBroadcast(w http.ResponseWriter, r *http.Request) {
+	var req broadcastRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	if req.Text == "" {
+		http.Error(w, "Nothing to broadcast", http.StatusBadRequest)
+		return
+	}
+	s.Announce(req.Text)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) serveWeather(w http.ResponseWriter, r *http.Request) {
+	var req weatherRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	weather, ok := ParseWeather(req.Weather)
+	if !ok {
+		http.Error(w, "Unknown weather: " + req.Weather, http.StatusBadRequest)
+		return
+	}
+	if err := s.SetWeather(req.Room, req.Location, weather); err == ErrNoRoom {
+		http.Error(w, "No room called " + req.Room, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Could not change weather: " + err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) serveTime(w http.ResponseWriter, r *http.Request) {
+	var req timeRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	hour, minute, ok := ParseClock(req.Time)
+	if !ok {
+		http.Error(w, "Expected a time such as 18:30, got " + req.Time, http.StatusBadRequest)
+		return
+	}
+	if err := s.SetTime(req.Room, hour, minute); err != nil {
+		http.Error(w, "No room called " + req.Room, http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/atemmel/pok/pkg/protocol"
+	"github.com/atemmel/pok/pkg/transport"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdmin(t *testing.T) {
+	network := transport.NewMemory(6, transport.Conditions{})
+	s := startTestServer(t, network, Config{AdminToken: "secret"})
+	alice := dialTestClient(t, network, "Alice", "")
+
+	admin := httptest.NewServer(s.AdminHandler())
+	defer admin.Close()
+
+	response, err := http.Get(admin.URL + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status Status
+	err = json.NewDecoder(response.Body).Decode(&status)
+	response.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Players) != 1 || status.Players[0].Name != "Alice" || status.Players[0].BytesIn == 0 {
+		t.Errorf("Status did not show Alice: %+v", status.Players)
+	}
+
+	post := func(path, token, body string) int {
+		request, _ := http.NewRequest(http.MethodPost, admin.URL + path, strings.NewReader(body))
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer " + token)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		return response.StatusCode
+	}
+
+	type adminTest struct {
+		Path, Token, Body string
+		Want int
+	}
+
+	tests := []adminTest{
+		{"/admin/broadcast", "", `{"Text": "hello"}`, http.StatusUnauthorized},
+		{"/admin/broadcast", "wrong", `{"Text": "hello"}`, http.StatusUnauthorized},
+		{"/admin/broadcast", "secret", `{"Text": "hello"}`, http.StatusNoContent},
+		{"/admin/broadcast", "secret", `{"Text": `, http.StatusBadRequest},
+		{"/admin/time", "secret", `{"Time": "25:00"}`, http.StatusBadRequest},
+		{"/admin/time", "secret", `{"Time": "18:30"}`, http.StatusNoContent},
+		{"/admin/weather", "secret", `{"Location": "test", "Weather": "rain"}`, http.StatusNoContent},
+		{"/admin/kick", "secret", `{"Player": "Bob"}`, http.StatusNotFound},
+		{"/admin/kick", "secret", `{"Player": "alice", "Reason": "Testing"}`, http.StatusNoContent},
+	}
+
+	for _, test := range tests {
+		if output := post(test.Path, test.Token, test.Body); output != test.Want {
+			t.Errorf("Status %d not equal to %d for %s %s", output, test.Want, test.Path, test.Body)
+		}
+	}
+
+	alice.expect("the broadcast", func(msg protocol.Message) bool {
+		announcement, ok := msg.(*protocol.Announcement)
+		return ok && announcement.Text == "hello"
+	})
+	alice.expect("being kicked", func(msg protocol.Message) bool {
+		kick, ok := msg.(*protocol.Kick)
+		return ok && kick.Reason == "Testing"
+	})
+	if hour, minute, _ := s.Time(""); hour != 18 || minute != 30 {
+		t.Errorf("Time %s not equal to 18:30", FormatClock(hour, minute))
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// Longer messages are cut short
+	MaxChatLength = 120
+	// A player may send this many messages in a row...
+	chatBurst = 4
+	// ...after which they may send one per this interval
+	chatRefill = 2 * time.Second
+	// Players that keep flooding are muted for this long
+	chatMuteDuration = 30 * time.Second
+	// How many dropped messages it takes to get muted
+	chatStrikes = 3
+)
+
+// DefaultChatFilter is used if the config does not specify any words
+var DefaultChatFilter = []string{
+	"ass",
+	"asshole",
+	"bastard",
+	"bitch",
+	"cunt",
+	"dick",
+	"fuck",
+	"fucking",
+	"piss",
+	"shit",
+}
+
+// chatLimiter is a token bucket, used to keep a single player from
+// drowning out everyone else
+type chatLimiter struct {
+	tokens float64
+	last time.Time
+	strikes int
+	mutedUntil time.Time
+}
+
+// allow reports whether a message sent at now may go through, and if not,
+// whether the player just got muted because of it
+func (l *chatLimiter) allow(now time.Time) (ok bool, muted bool) {
+	if now.Before(l.mutedUntil) {
+		return false, false
+	}
+
+	if l.last.IsZero() {
+		l.tokens = chatBurst
+	} else {
+		l.tokens += float64(now.Sub(l.last)) / float64(chatRefill)
+		if l.tokens > chatBurst {
+			l.tokens = chatBurst
+		}
+	}
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		l.strikes = 0
+		return true, false
+	}
+
+	l.strikes++
+	if l.strikes >= chatStrikes {
+		l.strikes = 0
+		l.mutedUntil = now.Add(chatMuteDuration)
+		return false, true
+	}
+	return false, false
+}
+
+// sanitizeChat strips anything that cannot be drawn and enforces
+// MaxChatLength. An empty result means the message should be dropped.
+func sanitizeChat(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return ' '
+		} else if r == utf8.RuneError || !unicode.IsPrint(r) {
+			return -1
+		}
+		return r
+	}, text)
+
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) > MaxChatLength {
+		text = string([]rune(text)[:MaxChatLength])
+	}
+	return text
+}
+
+// chatFilter censors words from a list, ignoring case and any punctuation
+// attached to the word
+type chatFilter struct {
+	words map[string]bool
+}
+
+func newChatFilter(words []string) chatFilter {
+	filter := chatFilter{make(map[string]bool)}
+	for _, w := range words {
+		filter.words[strings.ToLower(w)] = true
+	}
+	return filter
+}
+
+func (f *chatFilter) censor(text string) string {
+	runes := []rune(text)
+	start := -1
+
+	for i := 0; i <= len(runes); i++ {
+		if i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+
+		if start >= 0 && f.words[strings.ToLower(string(runes[start:i]))] {
+			for j := start; j < i; j++ {
+				runes[j] = '*'
+			}
+		}
+		start = -1
+	}
+
+	return string(runes)
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSanitizeChat(t *testing.T) {
+	type sanitizeTest struct {
+		In string
+		Want string
+	}
+
+	tests := []sanitizeTest{
+		{"", ""},
+		{"   \t ", ""},
+		{"hello", "hello"},
+		{"  hello   there  ", "hello there"},
+		{"bell\a and\nnewline", "bell and newline"},
+		{strings.Repeat("a", MaxChatLength + 10), strings.Repeat("a", MaxChatLength)},
+		{strings.Repeat("ö", MaxChatLength + 1), strings.Repeat("ö", MaxChatLength)},
+	}
+
+	for _, test := range tests {
+		if output := sanitizeChat(test.In); output != test.Want {
+			t.Errorf("Output %q not equal to %q", output, test.Want)
+		}
+	}
+}
+
+func TestCensor(t *testing.T) {
+	type censorTest struct {
+		In string
+		Want string
+	}
+
+	filter := newChatFilter([]string{"heck", "Darn"})
+	tests := []censorTest{
+		{"what the heck", "what the ****"},
+		{"HECK!", "****!"},
+		{"darn,heck", "****,****"},
+		{"checkmate", "checkmate"},
+		{"heckin", "heckin"},
+	}
+
+	for _, test := range tests {
+		if output := filter.censor(test.In); output != test.Want {
+			t.Errorf("Output %q not equal to %q", output, test.Want)
+		}
+	}
+}
+
+func TestChatLimiter(t *testing.T) {
+	start := time.Unix(1000, 0)
+	l := chatLimiter{}
+
+	for i := 0; i < chatBurst; i++ {
+		if ok, _ := l.allow(start); !ok {
+			t.Fatalf("Message %d of burst was dropped", i)
+		}
+	}
+
+	if ok, _ := l.allow(start); ok {
+		t.Errorf("Message past burst was allowed")
+	}
+
+	if ok, _ := l.allow(start.Add(chatRefill)); !ok {
+		t.Errorf("Message after refill was dropped")
+	}
+
+	muted := false
+	for i := 0; i < chatStrikes; i++ {
+		_, muted = l.allow(start.Add(chatRefill))
+	}
+	if !muted {
+		t.Errorf("Flooding did not lead to a mute")
+	}
+
+	if ok, _ := l.allow(start.Add(chatRefill + chatMuteDuration / 2)); ok {
+		t.Errorf("Muted player was allowed to chat")
+	}
+
+	if ok, _ := l.allow(start.Add(chatRefill * 2 + chatMuteDuration)); !ok {
+		t.Errorf("Player was still muted after the mute ended")
+	}
+}
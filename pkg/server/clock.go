@@ -0,0 +1,100 @@
+package server
+
+import (
+	"fmt"
+	"github.com/atemmel/pok/pkg/protocol"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const secondsPerDay = 24 * 60 * 60
+
+// worldClock is the time of day shared by every player. It starts out at
+// the local time of the server, and runs DayLength times faster than real
+// time.
+type worldClock struct {
+	seconds float64	// since midnight, at setAt
+	setAt time.Time
+	rate float64	// world seconds per real second
+}
+
+func newWorldClock(now time.Time, dayLength int) worldClock {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return worldClock{
+		now.Sub(midnight).Seconds(),
+		now,
+		float64(secondsPerDay) / float64(dayLength),
+	}
+}
+
+// at returns the number of seconds since midnight in the world
+func (c *worldClock) at(now time.Time) float64 {
+	seconds := c.seconds + now.Sub(c.setAt).Seconds() * c.rate
+	return math.Mod(seconds, secondsPerDay)
+}
+
+func (c *worldClock) set(now time.Time, seconds float64) {
+	c.seconds = math.Mod(seconds, secondsPerDay)
+	if c.seconds < 0 {
+		c.seconds += secondsPerDay
+	}
+	c.setAt = now
+}
+
+func (c *worldClock) message(now time.Time) *protocol.Clock {
+	return &protocol.Clock{Seconds: c.at(now), Rate: c.rate}
+}
+
+// ParseClock reads a time of day such as 18:30
+func ParseClock(text string) (int, int, bool) {
+	parts := strings.Split(text, ":")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil || hour < 0 || hour > 23 {
+		return 0, 0, false
+	}
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil || minute < 0 || minute > 59 {
+		return 0, 0, false
+	}
+	return hour, minute, true
+}
+
+// FormatClock is the opposite of ParseClock
+func FormatClock(hour, minute int) string {
+	return fmt.Sprintf("%02d:%02d", hour, minute)
+}
+
+// Time returns the time of day in a room
+func (s *Server) Time(roomName string) (hour, minute int, err error) {
+	s.connsMutex.Lock()
+	defer s.connsMutex.Unlock()
+
+	r, err := s.room(roomName)
+	if err != nil {
+		return 0, 0, err
+	}
+	hour, minute = r.time(time.Now())
+	return hour, minute, nil
+}
+
+// SetTime changes the time of day for everyone in a room
+func (s *Server) SetTime(roomName string, hour, minute int) error {
+	s.connsMutex.Lock()
+	defer s.connsMutex.Unlock()
+
+	r, err := s.room(roomName)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	r.clock.set(now, float64(hour * 3600 + minute * 60))
+	bytes, _ := protocol.Encode(r.clock.message(now))
+	s.writeRoom(r, bytes)
+	return nil
+}
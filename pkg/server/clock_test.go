@@ -0,0 +1,60 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWorldClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	// An hour-long day, so one real second is 24 world seconds
+	clock := newWorldClock(start, 60 * 60)
+
+	type clockTest struct {
+		Elapsed time.Duration
+		Want float64
+	}
+
+	tests := []clockTest{
+		{0, 12 * 60 * 60},
+		{time.Second, 12 * 60 * 60 + 24},
+		{30 * time.Minute, 0},
+		{45 * time.Minute, 6 * 60 * 60},
+	}
+
+	for _, test := range tests {
+		if output := clock.at(start.Add(test.Elapsed)); output != test.Want {
+			t.Errorf("Output %v not equal to %v after %v", output, test.Want, test.Elapsed)
+		}
+	}
+
+	clock.set(start, -60)
+	if output := clock.at(start); output != secondsPerDay - 60 {
+		t.Errorf("Output %v not equal to %v after setting a negative time", output, secondsPerDay - 60)
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	type parseClockTest struct {
+		In string
+		Hour, Minute int
+		Ok bool
+	}
+
+	tests := []parseClockTest{
+		{"18:30", 18, 30, true},
+		{"0:05", 0, 5, true},
+		{"23:59", 23, 59, true},
+		{"24:00", 0, 0, false},
+		{"12:60", 0, 0, false},
+		{"12", 0, 0, false},
+		{"noon", 0, 0, false},
+	}
+
+	for _, test := range tests {
+		hour, minute, ok := ParseClock(test.In)
+		if hour != test.Hour || minute != test.Minute || ok != test.Ok {
+			t.Errorf("Output %d, %d, %v not equal to %d, %d, %v for %q", hour, minute, ok, test.Hour, test.Minute, test.Ok, test.In)
+		}
+	}
+}
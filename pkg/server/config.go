@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/atemmel/pok/pkg/protocol"
+	"github.com/atemmel/pok/pkg/transport"
+	"io/ioutil"
+)
+
+const DefaultConfigPath = "./config_server.json"
+const DefaultAccountsPath = "./accounts.json"
+const DefaultPlayersPath = "./players.json"
+
+const (
+	DefaultPort = "6567"
+	DefaultTimeout = 5000
+	DefaultMaxConnections = 16
+	DefaultResumeGrace = 10000
+)
+
+type Config struct {
+	Url string
+	Port string
+	Timeout int	// in milliseconds, without hearing from a client
+	ResumeGrace int	// in milliseconds, before others are told that a player dropped
+	MaxConnections int	// per room, unless the room says otherwise
+	Rooms []RoomConfig	// a single DefaultRoom if empty, players join the first by default
+	ChatFilter []string	// words to censor, DefaultChatFilter if empty
+	AccountsFile string
+	PlayersFile string	// where every account was when it was last seen
+	ClosedRegistration bool	// if true, unknown names are turned away
+	MapsDir string	// what player locations are relative to
+	StartLocation string	// where players that have not been seen before start, as clients name locations, anywhere if empty
+	StartEntry int	// on StartLocation
+	DayLength int	// in real seconds, a full day by default
+	WebSocketPort string	// to also accept websockets on, if not empty
+	WebSocketPath string
+	UdpPort string	// to also take player states over udp, if not empty
+	AdminAddr string	// to serve status and admin requests on, if not empty
+	AdminToken string	// required by admin requests, which are refused if empty
+	RecordFile string	// to record every message to, if not empty
+	Network transport.Network `json:"-"`	// transport.System if nil
+}
+
+// ReadConfig reads a config as it is, so that it can still be overridden.
+// The defaults are filled in by NewServer.
+func ReadConfig(path string) (Config, error) {
+	var conf Config
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return conf, err
+	}
+
+	err = json.Unmarshal(data, &conf)
+	if err != nil {
+		return conf, err
+	}
+
+	return conf, nil
+}
+
+func (conf *Config) setDefaults() {
+	if conf.Port == "" {
+		conf.Port = DefaultPort
+	}
+
+	if conf.Timeout <= 0 {
+		conf.Timeout = DefaultTimeout
+	}
+
+	if conf.ResumeGrace <= 0 {
+		conf.ResumeGrace = DefaultResumeGrace
+	}
+
+	if conf.MaxConnections <= 0 {
+		conf.MaxConnections = DefaultMaxConnections
+	}
+
+	if conf.DayLength <= 0 {
+		conf.DayLength = secondsPerDay
+	}
+
+	// Copied, so that the defaults do not leak into the caller's rooms
+	rooms := conf.Rooms
+	if len(rooms) == 0 {
+		rooms = []RoomConfig{{Name: DefaultRoom}}
+	}
+	conf.Rooms = make([]RoomConfig, len(rooms))
+	for i, r := range rooms {
+		if r.Capacity <= 0 {
+			r.Capacity = conf.MaxConnections
+		}
+		if r.DayLength <= 0 {
+			r.DayLength = conf.DayLength
+		}
+		conf.Rooms[i] = r
+	}
+
+	if conf.WebSocketPath == "" {
+		conf.WebSocketPath = protocol.DefaultWebSocketPath
+	}
+
+	if conf.MapsDir == "" {
+		conf.MapsDir = "."
+	}
+
+	if conf.AccountsFile == "" {
+		conf.AccountsFile = DefaultAccountsPath
+	}
+
+	if conf.PlayersFile == "" {
+		conf.PlayersFile = DefaultPlayersPath
+	}
+
+	if len(conf.ChatFilter) == 0 {
+		conf.ChatFilter = DefaultChatFilter
+	}
+
+	if conf.Network == nil {
+		conf.Network = transport.System
+	}
+}
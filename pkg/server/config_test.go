@@ -0,0 +1,46 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadConfigOverride(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config_server.json")
+	data := `{"MaxConnections": 16, "Rooms": [{"Name": "main"}, {"Name": "arena", "Capacity": 2}]}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := ReadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// As with -max-connections
+	conf.MaxConnections = 4
+	conf.AccountsFile = filepath.Join(dir, "accounts.json")
+	conf.PlayersFile = filepath.Join(dir, "players.json")
+
+	s, err := NewServer(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	capacities := []int{}
+	for _, r := range s.Rooms() {
+		capacities = append(capacities, r.Capacity)
+	}
+	want := []int{4, 2}
+	if !reflect.DeepEqual(capacities, want) {
+		t.Errorf("Output %+v not equal to %+v", capacities, want)
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/atemmel/pok/pkg/constants"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var ErrBadLocation = errors.New("location is outside of the maps directory")
+
+// mapObject is a rock, tree or boulder. Like in pok.TileMap, Z is one above
+// the layer that the player walks on.
+type mapObject struct {
+	X, Y, Z int
+}
+
+type mapExit struct {
+	Target string
+	Id int
+	X, Y, Z int
+}
+
+type mapEntry struct {
+	Id int
+	X, Y, Z int
+}
+
+// mapData is the part of a pok.TileMap that movement is checked against.
+// The server cannot use pok.TileMap.OpenFile directly, since it loads the
+// textures of the map as well, but the files are the same.
+type mapData struct {
+	Tiles [][]int
+	Collision [][]bool
+	Exits []mapExit
+	Entries []mapEntry
+	Width int
+	Height int
+	Rocks []mapObject
+	Boulders []mapObject
+	CuttableTrees []mapObject
+	WeatherKind int
+}
+
+// resolveLocation turns a location reported by a client into paths to try,
+// mirroring the fallbacks of pok.TileMap.OpenFile. Locations may not point
+// outside of dir.
+func resolveLocation(dir, location string) ([]string, error) {
+	clean := path.Clean(filepath.ToSlash(location))
+	if location == "" || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return nil, ErrBadLocation
+	}
+
+	base := path.Base(clean)
+	return []string{
+		filepath.Join(dir, filepath.FromSlash(clean)),
+		filepath.Join(dir, base),
+		filepath.Join(dir, filepath.FromSlash(constants.TileMapDir), base),
+	}, nil
+}
+
+func loadMap(dir, location string) (*mapData, error) {
+	paths, err := resolveLocation(dir, location)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	for _, p := range paths {
+		data, err = ioutil.ReadFile(p)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m := &mapData{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+
+	if m.Width <= 0 || m.Height <= 0 || len(m.Collision) != len(m.Tiles) {
+		return nil, errors.New("map has no size or mismatched layers")
+	}
+	for _, layer := range m.Collision {
+		if len(layer) != m.Width * m.Height {
+			return nil, errors.New("collision layer does not match map size")
+		}
+	}
+
+	return m, nil
+}
+
+func (m *mapData) inBounds(x, y, z int) bool {
+	return x >= 0 && x < m.Width && y >= 0 && y < m.Height && z >= 0 && z < len(m.Collision)
+}
+
+// blocked reports whether a player could not stand at x, y, z, taking
+// what players have done to the map into account
+func (m *mapData) blocked(x, y, z int, world *mapState) bool {
+	if !m.inBounds(x, y, z) || m.Collision[z][y * m.Width + x] {
+		return true
+	}
+
+	at := tile{x, y, z}
+	for _, rock := range m.Rocks {
+		if rock.X == x && rock.Y == y && rock.Z == z + 1 && !world.smashed[at] {
+			return true
+		}
+	}
+
+	for _, tree := range m.CuttableTrees {
+		if tree.X == x && tree.Y == y && tree.Z == z + 1 && !world.cut[at] {
+			return true
+		}
+	}
+
+	if _, ok := world.boulders[at]; ok {
+		return true
+	}
+	for _, boulder := range m.Boulders {
+		if boulder.X == x && boulder.Y == y && boulder.Z == z + 1 && !world.boulderMovedFrom(at) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// entry returns where a player ends up when arriving through an exit with
+// the given id, just like pok.Game.Load. Players keep their layer when
+// changing maps, so only x and y are given.
+func (m *mapData) entry(id int) (int, int) {
+	for _, e := range m.Entries {
+		if e.Id == id {
+			return e.X, e.Y
+		}
+	}
+	return 0, 0
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"errors"
+	"github.com/atemmel/pok/pkg/protocol"
+	"log"
+	"net"
+	"time"
+)
+
+const (
+	// A little faster than biking, which covers four pixels a frame
+	maxTilesPerSecond = 16
+	// How far ahead of the average speed a player may get, to make up for
+	// updates that arrive in bursts
+	maxMoveBurst = 4
+	// How far from an exit a player may have last been seen before showing
+	// up on the map it leads to
+	maxExitDistance = 2
+	// States that arrive this soon after a correction are dropped without
+	// counting against the player, as they were sent before it arrived
+	correctionGrace = time.Second
+	// Players with this many illegal moves within offenseWindow are flagged
+	flagOffenses = 5
+	offenseWindow = time.Minute
+)
+
+var (
+	errBlocked = errors.New("position is blocked")
+	errWall = errors.New("moved through something solid")
+	errLayer = errors.New("skipped a layer")
+	errNoExit = errors.New("changed map without using an exit")
+	errTooFast = errors.New("moved too fast")
+)
+
+// moveCheck holds what is needed to tell if a single move is possible
+type moveCheck struct {
+	prev *protocol.PlayerState	// nil for the first state of a session
+	next *protocol.PlayerState
+	from *mapData	// the map of prev
+	to *mapData	// the map of next
+	world *mapState	// what has been done to the map of next
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// distance returns how many tiles the move covers, or an error if the move
+// is impossible no matter how much time has passed
+func (c *moveCheck) distance() (int, error) {
+	next := c.next
+	if c.to.blocked(next.X, next.Y, next.Z, c.world) {
+		return 0, errBlocked
+	}
+
+	prev := c.prev
+	if prev == nil {
+		return 0, nil
+	}
+
+	if prev.Location != next.Location {
+		for _, exit := range c.from.Exits {
+			if exit.Target != next.Location || abs(exit.X - prev.X) + abs(exit.Y - prev.Y) > maxExitDistance {
+				continue
+			}
+			x, y := c.to.entry(exit.Id)
+			return abs(next.X - x) + abs(next.Y - y), nil
+		}
+		return 0, errNoExit
+	}
+
+	dx, dy, dz := next.X - prev.X, next.Y - prev.Y, next.Z - prev.Z
+	if abs(dz) > 1 {
+		return 0, errLayer
+	}
+
+	// Check the tiles in between for straight moves on the same layer, which
+	// is what a player trying to pass through a wall would send
+	if dz == 0 && (dx == 0 || dy == 0) {
+		stepX, stepY := sign(dx), sign(dy)
+		for x, y := prev.X + stepX, prev.Y + stepY; x != next.X || y != next.Y; x, y = x + stepX, y + stepY {
+			if c.to.blocked(x, y, next.Z, c.world) {
+				return 0, errWall
+			}
+		}
+	}
+
+	return abs(dx) + abs(dy), nil
+}
+
+func sign(x int) int {
+	if x < 0 {
+		return -1
+	} else if x > 0 {
+		return 1
+	}
+	return 0
+}
+
+// moveTracker keeps track of how fast a player is moving, and of how often
+// it has been caught cheating
+type moveTracker struct {
+	budget float64	// in tiles
+	last time.Time
+	correctedAt time.Time
+	offenses []time.Time
+	flagged bool
+}
+
+// spend reports whether the player could have covered that many tiles since
+// its last move
+func (t *moveTracker) spend(now time.Time, tiles int) bool {
+	if t.last.IsZero() {
+		t.budget = maxMoveBurst
+	} else {
+		t.budget += now.Sub(t.last).Seconds() * maxTilesPerSecond
+		if t.budget > maxMoveBurst {
+			t.budget = maxMoveBurst
+		}
+	}
+	t.last = now
+
+	if float64(tiles) > t.budget {
+		return false
+	}
+	t.budget -= float64(tiles)
+	return true
+}
+
+// offend records an illegal move, returning true if this is what got the
+// player flagged
+func (t *moveTracker) offend(now time.Time) bool {
+	recent := t.offenses[:0]
+	for _, at := range t.offenses {
+		if now.Sub(at) < offenseWindow {
+			recent = append(recent, at)
+		}
+	}
+	t.offenses = append(recent, now)
+
+	if !t.flagged && len(t.offenses) >= flagOffenses {
+		t.flagged = true
+		return true
+	}
+	return false
+}
+
+// Assumes that connsMutex is held
+func (s *Server) mapData(location string) (*mapData, error) {
+	if m, ok := s.maps[location]; ok {
+		return m, nil
+	}
+
+	m, err := loadMap(s.conf.MapsDir, location)
+	if err != nil {
+		return nil, err
+	}
+	s.maps[location] = m
+	return m, nil
+}
+
+// Assumes that connsMutex is held
+func (s *Server) checkMove(sess *session, next *protocol.PlayerState) error {
+	to, err := s.mapData(next.Location)
+	if err != nil {
+		return err
+	}
+
+	check := moveCheck{prev: sess.state, next: next, to: to, world: sess.room.world(next.Location)}
+	if sess.state != nil {
+		check.from, err = s.mapData(sess.state.Location)
+		if err != nil {
+			return err
+		}
+	}
+
+	tiles, err := check.distance()
+	if err != nil {
+		return err
+	}
+
+	if !sess.moves.spend(time.Now(), tiles) {
+		return errTooFast
+	}
+	return nil
+}
+
+// acceptMove checks a reported state before it is passed on. Players that
+// make illegal moves are sent back to where they were last seen.
+// Assumes that connsMutex is held.
+func (s *Server) acceptMove(conn net.Conn, sess *session, next *protocol.PlayerState) bool {
+	err := s.checkMove(sess, next)
+	if err == nil {
+		return true
+	}
+
+	now := time.Now()
+	if now.Sub(sess.moves.correctedAt) < correctionGrace {
+		return false
+	}
+
+	log.Printf("Illegal move by %s to %s %d,%d,%d: %v\n", sess.name, next.Location, next.X, next.Y, next.Z, err)
+	if sess.moves.offend(now) {
+		log.Println("Player", sess.name, "has been flagged for repeated illegal moves")
+	}
+
+	if prev := sess.state; prev != nil {
+		protocol.WriteMessage(conn, &protocol.Correction{Location: prev.Location, X: prev.X, Y: prev.Y, Z: prev.Z})
+		sess.moves.correctedAt = now
+	}
+	return false
+}
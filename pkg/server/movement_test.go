@@ -0,0 +1,137 @@
+package server
+
+import (
+	"github.com/atemmel/pok/pkg/protocol"
+	"testing"
+	"time"
+)
+
+// testMap is 5x5 with a wall down the middle column, except for a gap at
+// y = 4, and an exit at 0,0 leading to "other"
+func testMap() *mapData {
+	collision := make([]bool, 5 * 5)
+	for y := 0; y < 4; y++ {
+		collision[y * 5 + 2] = true
+	}
+
+	return &mapData{
+		Tiles: [][]int{make([]int, 5 * 5)},
+		Collision: [][]bool{collision},
+		Exits: []mapExit{{"other", 1, 0, 0, 0}},
+		Entries: []mapEntry{{1, 3, 3, 0}},
+		Width: 5,
+		Height: 5,
+		Rocks: []mapObject{{1, 4, 1}},
+	}
+}
+
+func TestMoveDistance(t *testing.T) {
+	m := testMap()
+	world := newMapState()
+
+	at := func(location string, x, y int) *protocol.PlayerState {
+		return &protocol.PlayerState{Location: location, X: x, Y: y}
+	}
+
+	type distanceTest struct {
+		Prev *protocol.PlayerState
+		Next *protocol.PlayerState
+		Want int
+		WantErr error
+	}
+
+	tests := []distanceTest{
+		{nil, at("test", 0, 0), 0, nil},
+		{at("test", 0, 0), at("test", 0, 1), 1, nil},
+		{at("test", 0, 0), at("test", 1, 1), 2, nil},
+		{at("test", 1, 0), at("test", 2, 0), 0, errBlocked},
+		{at("test", 1, 0), at("test", 3, 0), 0, errWall},
+		{at("test", 0, 4), at("test", 1, 4), 0, errBlocked},
+		{at("test", 0, 0), at("test", 5, 0), 0, errBlocked},
+		{at("test", 1, 1), at("other", 3, 2), 1, nil},
+		{at("test", 4, 4), at("other", 3, 3), 0, errNoExit},
+		{at("test", 0, 0), at("elsewhere", 3, 3), 0, errNoExit},
+	}
+
+	for _, test := range tests {
+		check := moveCheck{test.Prev, test.Next, m, m, world}
+		output, err := check.distance()
+		if output != test.Want || err != test.WantErr {
+			t.Errorf("Move from %+v to %+v gave %d, %v, expected %d, %v", test.Prev, test.Next, output, err, test.Want, test.WantErr)
+		}
+	}
+
+	// Smashing the rock opens up the tile
+	world.apply(&protocol.WorldEvent{Event: protocol.RockSmashed, X: 1, Y: 4})
+	check := moveCheck{at("test", 0, 4), at("test", 1, 4), m, m, world}
+	if _, err := check.distance(); err != nil {
+		t.Errorf("Could not move onto smashed rock: %v", err)
+	}
+}
+
+func TestMoveTracker(t *testing.T) {
+	start := time.Unix(1000, 0)
+	tr := moveTracker{}
+
+	if !tr.spend(start, maxMoveBurst) {
+		t.Errorf("Could not spend initial burst")
+	}
+	if tr.spend(start, 1) {
+		t.Errorf("Moved without any time passing")
+	}
+	if !tr.spend(start.Add(time.Second / maxTilesPerSecond), 1) {
+		t.Errorf("Could not move a tile after waiting for it")
+	}
+
+	for i := 0; i < flagOffenses - 1; i++ {
+		if tr.offend(start) {
+			t.Fatalf("Flagged after %d offenses", i + 1)
+		}
+	}
+	if tr.offend(start.Add(offenseWindow)) {
+		t.Errorf("Old offenses were counted")
+	}
+	for i := 0; i < flagOffenses - 2; i++ {
+		tr.offend(start.Add(offenseWindow))
+	}
+	if !tr.offend(start.Add(offenseWindow)) {
+		t.Errorf("Not flagged after %d offenses", flagOffenses)
+	}
+}
+
+func TestResolveLocation(t *testing.T) {
+	type resolveTest struct {
+		In string
+		WantErr error
+	}
+
+	tests := []resolveTest{
+		{"resources/tilemaps/old.json", nil},
+		{"old.json", nil},
+		{"", ErrBadLocation},
+		{"/etc/passwd", ErrBadLocation},
+		{"../config_server.json", ErrBadLocation},
+		{"resources/../../secret", ErrBadLocation},
+	}
+
+	for _, test := range tests {
+		if _, err := resolveLocation(".", test.In); err != test.WantErr {
+			t.Errorf("resolveLocation(%q) gave %v, expected %v", test.In, err, test.WantErr)
+		}
+	}
+}
+
+func TestLoadMap(t *testing.T) {
+	// Exits name their target without a directory, so just like in
+	// pok.TileMap.OpenFile those are found through a fallback
+	for _, location := range []string{"resources/tilemaps/cave.json", "cave.json"} {
+		m, err := loadMap("../..", location)
+		if err != nil {
+			t.Errorf("Could not load %q: %v", location, err)
+			continue
+		}
+		if len(m.Exits) == 0 || m.Exits[0].Target != "old.json" {
+			t.Errorf("Unexpected exits in %q: %+v", location, m.Exits)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/atemmel/pok/pkg/protocol"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often positions are written to disk while players are moving. They
+// are also written whenever a player leaves, and when the server stops.
+const playersSaveInterval = 10 * time.Second
+
+// savedPlayer is where an account was when it was last seen
+type savedPlayer struct {
+	Name string
+	Location string
+	X, Y, Z int
+	Facing uint8
+	Mode protocol.MovementMode
+}
+
+// playerStore keeps the last position of every account in a JSON file.
+// Positions change far more often than accounts do, so they are kept in
+// memory and only written out every now and then.
+type playerStore struct {
+	path string
+	players map[string]*savedPlayer	// by lower case name
+	dirty bool	// if there are changes that have not been saved
+	savedAt time.Time
+	mutex sync.Mutex
+}
+
+func loadPlayers(path string) (*playerStore, error) {
+	store := &playerStore{
+		path: path,
+		players: make(map[string]*savedPlayer),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var players []savedPlayer
+	if err := json.Unmarshal(data, &players); err != nil {
+		return nil, err
+	}
+
+	for i := range players {
+		store.players[strings.ToLower(players[i].Name)] = &players[i]
+	}
+	return store, nil
+}
+
+// get returns where an account left off, if it has played before
+func (s *playerStore) get(name string) (savedPlayer, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	saved, ok := s.players[strings.ToLower(name)]
+	if !ok {
+		return savedPlayer{}, false
+	}
+	return *saved, true
+}
+
+// set remembers the latest accepted state of an account, without saving it
+func (s *playerStore) set(name string, state *protocol.PlayerState) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.players[strings.ToLower(name)] = &savedPlayer{
+		name,
+		state.Location,
+		state.X,
+		state.Y,
+		state.Z,
+		state.Avatar.Facing,
+		state.Avatar.Mode,
+	}
+	s.dirty = true
+}
+
+// flush saves the store if anything has changed since the last save, and
+// either force is set or playersSaveInterval has passed
+func (s *playerStore) flush(now time.Time, force bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.dirty || !force && now.Sub(s.savedAt) < playersSaveInterval {
+		return
+	}
+
+	if err := s.save(); err != nil {
+		log.Println("Could not save player positions:", err)
+		return
+	}
+	s.dirty = false
+	s.savedAt = now
+}
+
+// Assumes that mutex is held
+func (s *playerStore) save() error {
+	players := make([]savedPlayer, 0, len(s.players))
+	for _, saved := range s.players {
+		players = append(players, *saved)
+	}
+
+	data, err := json.MarshalIndent(players, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data)
+}
+
+// spawn looks up where a player left off, as long as that is still a place
+// it can stand in the room it joins. Players that have not been seen before,
+// or can not stand there, start at StartLocation if there is one. Assumes
+// that connsMutex is held.
+func (s *Server) spawn(name string, r *room) (protocol.Spawn, bool) {
+	saved, ok := s.players.get(name)
+	if ok && saved.Location != "" {
+		spawn := protocol.Spawn{
+			Location: saved.Location,
+			X: saved.X,
+			Y: saved.Y,
+			Z: saved.Z,
+			Facing: saved.Facing,
+			Mode: saved.Mode,
+		}
+		if s.canSpawn(spawn, r) {
+			return spawn, true
+		}
+		log.Println("Could not spawn", name, "where they left off")
+	}
+
+	if s.conf.StartLocation == "" {
+		return protocol.Spawn{}, false
+	}
+	m, err := s.mapData(s.conf.StartLocation)
+	if err != nil {
+		log.Println("Could not load the start location:", err)
+		return protocol.Spawn{}, false
+	}
+	x, y := m.entry(s.conf.StartEntry)
+	spawn := protocol.Spawn{Location: s.conf.StartLocation, X: x, Y: y, Mode: protocol.Walking}
+	if !s.canSpawn(spawn, r) {
+		return protocol.Spawn{}, false
+	}
+	return spawn, true
+}
+
+// Assumes that connsMutex is held
+func (s *Server) canSpawn(spawn protocol.Spawn, r *room) bool {
+	m, err := s.mapData(spawn.Location)
+	if err != nil {
+		log.Println("Could not load", spawn.Location, "to spawn on:", err)
+		return false
+	}
+	if m.blocked(spawn.X, spawn.Y, spawn.Z, r.world(spawn.Location)) {
+		log.Println("Could not spawn on", spawn.Location, "the position is blocked")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"github.com/atemmel/pok/pkg/protocol"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPlayerStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "players")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "players.json")
+
+	store, err := loadPlayers(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	store.set("Red", &protocol.PlayerState{Location: "beach", X: 4, Y: 5, Z: 1, Avatar: protocol.Avatar{Mode: protocol.Surfing, Facing: 3}})
+	store.flush(now, false)
+	store.set("Red", &protocol.PlayerState{Location: "cave", X: 6, Y: 7})
+
+	// Too soon to save again, unless forced
+	store.flush(now.Add(time.Second), false)
+	reloaded, err := loadPlayers(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved, _ := reloaded.get("red"); saved.Location != "beach" {
+		t.Errorf("Saved location %q not equal to %q", saved.Location, "beach")
+	}
+
+	store.flush(now.Add(time.Second), true)
+	reloaded, err = loadPlayers(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := savedPlayer{"Red", "cave", 6, 7, 0, 0, protocol.Walking}
+	if saved, ok := reloaded.get("RED"); !ok || saved != want {
+		t.Errorf("Saved player %+v not equal to %+v", saved, want)
+	}
+}
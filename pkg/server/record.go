@@ -0,0 +1,36 @@
+package server
+
+import (
+	"github.com/atemmel/pok/pkg/protocol"
+	"github.com/atemmel/pok/pkg/recording"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// wrap gives a new connection an id and starts counting, and recording,
+// what goes over it
+func (s *Server) wrap(conn net.Conn) *countingConn {
+	return &countingConn{
+		Conn: conn,
+		id: atomic.AddUint32(&s.connIds, 1),
+		total: &s.traffic,
+		recorder: s.recorder,
+		connectedAt: time.Now(),
+	}
+}
+
+// record adds a message to the recording, if the server is recording.
+// Connections record what is written to them by themselves, so this is for
+// what is read from them, and for datagrams.
+func (s *Server) record(conn net.Conn, direction recording.Direction, udp bool, msg protocol.Message) {
+	if c, ok := conn.(*countingConn); ok && s.recorder != nil {
+		s.recorder.RecordMessage(time.Now(), c.id, direction, udp, msg)
+	}
+}
+
+func (s *Server) recordClose(conn net.Conn) {
+	if c, ok := conn.(*countingConn); ok && s.recorder != nil {
+		s.recorder.RecordClose(time.Now(), c.id)
+	}
+}
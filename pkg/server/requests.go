@@ -0,0 +1,110 @@
+package server
+
+import (
+	"github.com/atemmel/pok/pkg/protocol"
+	"log"
+	"time"
+)
+
+// How long a player has to answer a request before it is dropped
+const requestTimeout = 30 * time.Second
+
+// pendingRequest is a request that has been asked but not yet answered
+type pendingRequest struct {
+	from *session
+	to *session
+	interaction protocol.Interaction
+	at time.Time
+}
+
+func (r *pendingRequest) message(status protocol.RequestStatus) *protocol.Request {
+	return &protocol.Request{From: r.from.id, To: r.to.id, Interaction: r.interaction, Status: status}
+}
+
+// request handles a request from sess, which either asks another player or
+// answers one. Assumes that connsMutex is held.
+func (s *Server) request(sess *session, m *protocol.Request) {
+	switch m.Status {
+		case protocol.RequestAsked:
+			s.ask(sess, m)
+		case protocol.RequestAccepted, protocol.RequestDeclined:
+			req, ok := s.requests[sess.id]
+			if !ok || req.from.id != m.To {
+				return
+			}
+			delete(s.requests, sess.id)
+			log.Println("Player", sess.id, "answered request from", req.from.id)
+			s.settle(req, m.Status)
+		default:
+			log.Println("Player", sess.id, "sent request with invalid status", m.Status)
+	}
+}
+
+// ask passes a request on if the other player is right next to the asker,
+// and neither of them is already part of another request.
+// Assumes that connsMutex is held.
+func (s *Server) ask(sess *session, m *protocol.Request) {
+	if m.Interaction > protocol.WaveInteraction {
+		log.Println("Player", sess.id, "sent request with invalid interaction", m.Interaction)
+		return
+	}
+
+	target := s.sessionById(m.To)
+	if target == nil || target == sess || target.conn == nil || !adjacent(sess, target) || s.busy(sess) || s.busy(target) {
+		if sess.conn != nil {
+			protocol.WriteMessage(sess.conn, &protocol.Request{From: sess.id, To: m.To, Interaction: m.Interaction, Status: protocol.RequestUnavailable})
+		}
+		return
+	}
+
+	req := &pendingRequest{sess, target, m.Interaction, time.Now()}
+	s.requests[target.id] = req
+	s.settle(req, protocol.RequestAsked)
+}
+
+// settle tells both players where a request stands.
+// Assumes that connsMutex is held.
+func (s *Server) settle(req *pendingRequest, status protocol.RequestStatus) {
+	bytes, err := protocol.Encode(req.message(status))
+	if err != nil {
+		log.Println("Could not encode request:", err)
+		return
+	}
+
+	for _, sess := range []*session{req.from, req.to} {
+		if sess.conn != nil {
+			sess.conn.Write(bytes)
+		}
+	}
+}
+
+// Assumes that connsMutex is held
+func (s *Server) busy(sess *session) bool {
+	for _, req := range s.requests {
+		if req.from == sess || req.to == sess {
+			return true
+		}
+	}
+	return false
+}
+
+// adjacent reports whether two players stand next to each other, close
+// enough to face one another
+func adjacent(a, b *session) bool {
+	if a.state == nil || b.state == nil || a.room != b.room || a.location == "" || a.location != b.location {
+		return false
+	}
+	return a.state.Z == b.state.Z && abs(a.state.X - b.state.X) + abs(a.state.Y - b.state.Y) == 1
+}
+
+// expireRequests drops requests that went unanswered for too long, and the
+// requests of leaving, unless it is nil.
+// Assumes that connsMutex is held.
+func (s *Server) expireRequests(now time.Time, leaving *session) {
+	for id, req := range s.requests {
+		if now.Sub(req.at) >= requestTimeout || req.from == leaving || req.to == leaving {
+			delete(s.requests, id)
+			s.settle(req, protocol.RequestExpired)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/atemmel/pok/pkg/protocol"
+	"net"
+	"time"
+)
+
+// DefaultRoom is the only room of a server that has none configured
+const DefaultRoom = "main"
+
+var ErrNoRoom = errors.New("no such room")
+
+// RoomConfig describes one of the rooms that a server hosts
+type RoomConfig struct {
+	Name string
+	Capacity int	// MaxConnections of the server if 0
+	DayLength int	// DayLength of the server if 0
+}
+
+// room is a world of its own. Players only ever see, talk to and interact
+// with players in the same room, and every room has its own world objects,
+// weather and time of day. Guarded by connsMutex.
+type room struct {
+	name string
+	capacity int
+	worlds map[string]*mapState	// by location
+	weather map[string]protocol.WeatherType	// by location
+	clock worldClock
+}
+
+// RoomStatus is a snapshot of a room, for operators
+type RoomStatus struct {
+	Name string
+	Capacity int
+	Players int	// including those that the server waits for to resume
+	Time string	// of day in the room
+	Weather map[string]string	// by location, for maps without their default weather
+}
+
+func newRoom(conf RoomConfig, now time.Time) *room {
+	return &room{
+		conf.Name,
+		conf.Capacity,
+		make(map[string]*mapState),
+		make(map[string]protocol.WeatherType),
+		newWorldClock(now, conf.DayLength),
+	}
+}
+
+// newRooms creates the rooms of a config, in the same order
+func newRooms(confs []RoomConfig, now time.Time) ([]*room, error) {
+	rooms := make([]*room, 0, len(confs))
+	names := make(map[string]bool)
+	for _, conf := range confs {
+		if conf.Name == "" {
+			return nil, errors.New("every room needs a name")
+		}
+		if names[conf.Name] {
+			return nil, fmt.Errorf("room %s is configured more than once", conf.Name)
+		}
+		names[conf.Name] = true
+		rooms = append(rooms, newRoom(conf, now))
+	}
+	return rooms, nil
+}
+
+// room looks up a room by name. The first room of the config is the one
+// that players end up in if they do not ask for any.
+func (s *Server) room(name string) (*room, error) {
+	if name == "" {
+		return s.rooms[0], nil
+	}
+	for _, r := range s.rooms {
+		if r.name == name {
+			return r, nil
+		}
+	}
+	return nil, ErrNoRoom
+}
+
+// population counts the players in a room, including those that the server
+// waits for to resume. Assumes that connsMutex is held.
+func (s *Server) population(r *room) int {
+	n := 0
+	for _, sess := range s.sessions {
+		if sess.room == r {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *room) world(location string) *mapState {
+	world, ok := r.worlds[location]
+	if !ok {
+		world = newMapState()
+		r.worlds[location] = world
+	}
+	return world
+}
+
+func (r *room) time(now time.Time) (hour, minute int) {
+	seconds := int(r.clock.at(now))
+	return seconds / 3600, seconds / 60 % 60
+}
+
+// writeRoom sends an already encoded message to every connected player in
+// a room. Assumes that connsMutex is held.
+func (s *Server) writeRoom(r *room, bytes []byte) {
+	for c, sess := range s.conns {
+		if sess.room == r {
+			c.Write(bytes)
+		}
+	}
+}
+
+// sendWorldState brings a player that just entered a map up to date with
+// what others in its room have done to it, in as many messages as it takes.
+// Assumes that connsMutex is held.
+func (s *Server) sendWorldState(conn net.Conn, r *room, location string) {
+	world, ok := r.worlds[location]
+	if !ok {
+		return
+	}
+
+	events := world.events()
+	for len(events) > 0 {
+		n := len(events)
+		if n > protocol.MaxWorldStateEvents {
+			n = protocol.MaxWorldStateEvents
+		}
+		protocol.WriteMessage(conn, &protocol.WorldState{Location: location, Events: events[:n]})
+		events = events[n:]
+	}
+}
+
+// Assumes that connsMutex is held
+func (s *Server) roomStatus(now time.Time) []RoomStatus {
+	rooms := make([]RoomStatus, 0, len(s.rooms))
+	for _, r := range s.rooms {
+		status := RoomStatus{
+			Name: r.name,
+			Capacity: r.capacity,
+			Players: s.population(r),
+			Time: FormatClock(r.time(now)),
+			Weather: make(map[string]string, len(r.weather)),
+		}
+		for location, kind := range r.weather {
+			status.Weather[location] = WeatherName(kind)
+		}
+		rooms = append(rooms, status)
+	}
+	return rooms
+}
+
+// Rooms lists the rooms of the server, in the order of the config
+func (s *Server) Rooms() []RoomStatus {
+	s.connsMutex.Lock()
+	defer s.connsMutex.Unlock()
+	return s.roomStatus(time.Now())
+}
@@ -0,0 +1,535 @@
+package server
+
+import (
+	"github.com/atemmel/pok/pkg/protocol"
+	"github.com/atemmel/pok/pkg/recording"
+	"github.com/atemmel/pok/pkg/transport"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Bad, but not hopeless
+var flakyNetwork = transport.Conditions{
+	Latency: 20 * time.Millisecond,
+	Jitter: 20 * time.Millisecond,
+	Loss: 0.05,
+	Reorder: 0.1,
+}
+
+const testAddr = "localhost:6567"
+
+// startTestServer runs a server on a memory network, with testMap loaded
+// as "test"
+func startTestServer(t *testing.T, network *transport.Memory, conf Config) *Server {
+	dir, err := ioutil.TempDir("", "pokserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	conf.Network = network
+	conf.Port = "6567"
+	conf.AccountsFile = filepath.Join(dir, "accounts.json")
+	conf.PlayersFile = filepath.Join(dir, "players.json")
+	s, err := NewServer(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.maps["test"] = testMap()
+
+	go s.Serve()
+	t.Cleanup(func() {
+		s.Shutdown("Test is over")
+	})
+	return s
+}
+
+// testClient speaks the protocol directly, so that scenarios can look at
+// exactly what the server sends
+type testClient struct {
+	t *testing.T
+	conn net.Conn
+	welcome *protocol.Welcome
+	messages chan protocol.Message
+}
+
+func dialTestClient(t *testing.T, network *transport.Memory, name, token string) *testClient {
+	t.Helper()
+	return dialTestRoom(t, network, "", name, token)
+}
+
+func dialTestRoom(t *testing.T, network *transport.Memory, room, name, token string) *testClient {
+	t.Helper()
+	conn, msg := handshakeTestClient(t, network, &protocol.Hello{Version: protocol.Version, Token: token, Name: name, Password: "password", Room: room, Spawn: true})
+	welcome, ok := msg.(*protocol.Welcome)
+	if !ok {
+		t.Fatalf("Expected welcome for %s, got %+v", name, msg)
+	}
+
+	c := &testClient{t, conn, welcome, make(chan protocol.Message, 256)}
+	go c.read()
+	return c
+}
+
+// handshakeTestClient connects, says hello and returns the first answer
+func handshakeTestClient(t *testing.T, network *transport.Memory, hello *protocol.Hello) (net.Conn, protocol.Message) {
+	t.Helper()
+
+	// The server may not be listening quite yet
+	var conn net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		if conn, err = network.Dial(testAddr); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	protocol.WriteMessage(conn, hello)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	msg, err := protocol.ReadMessage(conn)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, msg
+}
+
+func (c *testClient) read() {
+	for {
+		msg, err := protocol.ReadMessage(c.conn)
+		if err != nil {
+			close(c.messages)
+			return
+		}
+		c.messages <- msg
+	}
+}
+
+func (c *testClient) send(msg protocol.Message) {
+	if err := protocol.WriteMessage(c.conn, msg); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// expect skips messages until one matches, failing if none does in time
+func (c *testClient) expect(what string, match func(protocol.Message) bool) protocol.Message {
+	c.t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+			case msg, ok := <-c.messages:
+				if !ok {
+					c.t.Fatalf("%s lost connection while waiting for %s", c.welcome.Name, what)
+				}
+				if match(msg) {
+					return msg
+				}
+			case <-timeout:
+				c.t.Fatalf("%s timed out waiting for %s", c.welcome.Name, what)
+		}
+	}
+}
+
+// expectNone fails if a matching message arrives within d
+func (c *testClient) expectNone(what string, d time.Duration, match func(protocol.Message) bool) {
+	c.t.Helper()
+	timeout := time.After(d)
+	for {
+		select {
+			case msg, ok := <-c.messages:
+				if ok && match(msg) {
+					c.t.Fatalf("%s got %s: %+v", c.welcome.Name, what, msg)
+				}
+				if !ok {
+					return
+				}
+			case <-timeout:
+				return
+		}
+	}
+}
+
+func isJoin(id int) func(protocol.Message) bool {
+	return func(msg protocol.Message) bool {
+		join, ok := msg.(*protocol.Join)
+		return ok && join.Id == id
+	}
+}
+
+func isLeave(id int) func(protocol.Message) bool {
+	return func(msg protocol.Message) bool {
+		leave, ok := msg.(*protocol.Leave)
+		return ok && leave.Id == id
+	}
+}
+
+func isStateAt(id, x, y int) func(protocol.Message) bool {
+	return func(msg protocol.Message) bool {
+		state, ok := msg.(*protocol.PlayerState)
+		return ok && state.Id == id && state.X == x && state.Y == y
+	}
+}
+
+func TestScenarioJoinAndMove(t *testing.T) {
+	network := transport.NewMemory(1, flakyNetwork)
+	startTestServer(t, network, Config{})
+
+	alice := dialTestClient(t, network, "Alice", "")
+	bob := dialTestClient(t, network, "Bob", "")
+	alice.expect("Bob joining", isJoin(bob.welcome.Id))
+	bob.expect("Alice joining", isJoin(alice.welcome.Id))
+
+	alice.send(&protocol.PlayerState{Location: "test", X: 0, Y: 0})
+	bob.send(&protocol.PlayerState{Location: "test", X: 3, Y: 0})
+	bob.expect("Alice on the map", isStateAt(alice.welcome.Id, 0, 0))
+	alice.expect("Bob on the map", isStateAt(bob.welcome.Id, 3, 0))
+
+	alice.send(&protocol.PlayerState{Location: "test", X: 1, Y: 0})
+	bob.expect("Alice moving", isStateAt(alice.welcome.Id, 1, 0))
+
+	// Through the wall, which is refused and never passed on
+	alice.send(&protocol.PlayerState{Location: "test", X: 3, Y: 0})
+	alice.expect("a correction", func(msg protocol.Message) bool {
+		correction, ok := msg.(*protocol.Correction)
+		return ok && correction.X == 1 && correction.Y == 0
+	})
+	bob.expectNone("Alice passing through the wall", 200 * time.Millisecond, isStateAt(alice.welcome.Id, 3, 0))
+}
+
+func TestScenarioReconnect(t *testing.T) {
+	network := transport.NewMemory(2, flakyNetwork)
+	startTestServer(t, network, Config{ResumeGrace: 500})
+
+	alice := dialTestClient(t, network, "Alice", "")
+	bob := dialTestClient(t, network, "Bob", "")
+	alice.send(&protocol.PlayerState{Location: "test", X: 0, Y: 0})
+	bob.send(&protocol.PlayerState{Location: "test", X: 3, Y: 0})
+	bob.expect("Alice on the map", isStateAt(alice.welcome.Id, 0, 0))
+
+	// Coming back in time resumes the session, without Bob noticing
+	alice.conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	again := dialTestClient(t, network, "Alice", alice.welcome.Token)
+	if again.welcome.Id != alice.welcome.Id {
+		t.Errorf("Resumed with id %d, expected %d", again.welcome.Id, alice.welcome.Id)
+	}
+	again.expect("Bob on the map", isStateAt(bob.welcome.Id, 3, 0))
+	bob.expectNone("Alice leaving", 700 * time.Millisecond, isLeave(alice.welcome.Id))
+
+	// Not coming back does not
+	again.conn.Close()
+	bob.expect("Alice leaving", isLeave(alice.welcome.Id))
+
+	late := dialTestClient(t, network, "Alice", alice.welcome.Token)
+	if late.welcome.Id == alice.welcome.Id {
+		t.Errorf("Resumed an expired session")
+	}
+}
+
+func TestScenarioUdpStates(t *testing.T) {
+	network := transport.NewMemory(3, transport.Conditions{
+		Latency: 10 * time.Millisecond,
+		Jitter: 20 * time.Millisecond,
+		Loss: 0.2,
+		Reorder: 0.3,
+	})
+	startTestServer(t, network, Config{UdpPort: "6567"})
+
+	alice := dialTestClient(t, network, "Alice", "")
+	bob := dialTestClient(t, network, "Bob", "")
+	if alice.welcome.UdpPort != 6567 {
+		t.Fatalf("Udp port %d not equal to %d", alice.welcome.UdpPort, 6567)
+	}
+
+	alice.send(&protocol.PlayerState{Location: "test", X: 0, Y: 0})
+	bob.send(&protocol.PlayerState{Location: "test", X: 3, Y: 0})
+	bob.expect("Alice on the map", isStateAt(alice.welcome.Id, 0, 0))
+
+	udp, err := network.DialPacket(testAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+
+	// Gx counts up, so that Bob can tell whether anything arrived out of
+	// order. The stale state at the end must never make it through.
+	const sent = 40
+	for seq := 1; seq <= sent; seq++ {
+		state := &protocol.PlayerState{Location: "test", Gx: float64(seq)}
+		datagram, _ := protocol.EncodeDatagram(alice.welcome.UdpKey, uint32(seq), state)
+		udp.Write(datagram)
+		time.Sleep(5 * time.Millisecond)
+	}
+	stale := &protocol.PlayerState{Location: "test", Gx: -1}
+	datagram, _ := protocol.EncodeDatagram(alice.welcome.UdpKey, sent / 2, stale)
+	udp.Write(datagram)
+
+	last, received := 0.0, 0
+	bob.expectNone("a state out of order", time.Second, func(msg protocol.Message) bool {
+		state, ok := msg.(*protocol.PlayerState)
+		if !ok || state.Id != alice.welcome.Id {
+			return false
+		}
+		if state.Gx <= last {
+			return true
+		}
+		last = state.Gx
+		received++
+		return false
+	})
+
+	if received == 0 {
+		t.Errorf("None of the %d states sent over udp arrived", sent)
+	}
+}
+
+func TestScenarioSpawn(t *testing.T) {
+	network := transport.NewMemory(4, flakyNetwork)
+	startTestServer(t, network, Config{ResumeGrace: 100})
+
+	alice := dialTestClient(t, network, "Alice", "")
+	if alice.welcome.Spawn.Location != "" {
+		t.Errorf("New player given spawn %+v", alice.welcome.Spawn)
+	}
+
+	bob := dialTestClient(t, network, "Bob", "")
+	bob.send(&protocol.PlayerState{Location: "test", X: 3, Y: 0})
+	alice.send(&protocol.PlayerState{Location: "test", X: 0, Y: 0})
+	alice.send(&protocol.PlayerState{Location: "test", X: 1, Y: 0, Avatar: protocol.Avatar{Mode: protocol.Biking, Facing: 2}})
+	bob.expect("Alice moving", isStateAt(alice.welcome.Id, 1, 0))
+
+	alice.conn.Close()
+	bob.expect("Alice leaving", isLeave(alice.welcome.Id))
+
+	again := dialTestClient(t, network, "alice", "")
+	want := protocol.Spawn{Location: "test", X: 1, Y: 0, Z: 0, Facing: 2, Mode: protocol.Biking}
+	if again.welcome.Spawn != want {
+		t.Errorf("Spawned at %+v, expected %+v", again.welcome.Spawn, want)
+	}
+
+	// Moves are checked from the spawn, not from nowhere
+	again.send(&protocol.PlayerState{Location: "test", X: 3, Y: 0})
+	again.expect("a correction", func(msg protocol.Message) bool {
+		correction, ok := msg.(*protocol.Correction)
+		return ok && correction.X == 1 && correction.Y == 0
+	})
+
+	// A client that places the player itself is not told, but its moves
+	// are still checked from the spawn
+	again.conn.Close()
+	bob.expect("Alice leaving again", isLeave(again.welcome.Id))
+	conn, msg := handshakeTestClient(t, network, &protocol.Hello{Version: protocol.Version, Name: "alice", Password: "password"})
+	placed, ok := msg.(*protocol.Welcome)
+	if !ok {
+		t.Fatalf("Expected welcome, got %+v", msg)
+	}
+	if placed.Spawn.Location != "" {
+		t.Errorf("Placed player given spawn %+v", placed.Spawn)
+	}
+	self := &testClient{t, conn, placed, make(chan protocol.Message, 256)}
+	go self.read()
+	defer conn.Close()
+	self.send(&protocol.PlayerState{Location: "test", X: 3, Y: 0})
+	self.expect("a correction", func(msg protocol.Message) bool {
+		correction, ok := msg.(*protocol.Correction)
+		return ok && correction.X == 1 && correction.Y == 0
+	})
+}
+
+func TestScenarioStartLocation(t *testing.T) {
+	network := transport.NewMemory(4, flakyNetwork)
+	startTestServer(t, network, Config{StartLocation: "test", StartEntry: 1})
+
+	alice := dialTestClient(t, network, "Alice", "")
+	want := protocol.Spawn{Location: "test", X: 3, Y: 3, Mode: protocol.Walking}
+	if alice.welcome.Spawn != want {
+		t.Errorf("Spawned at %+v, expected %+v", alice.welcome.Spawn, want)
+	}
+
+	// New players can not start anywhere they like either
+	alice.send(&protocol.PlayerState{Location: "test", X: 0, Y: 0})
+	alice.expect("a correction", func(msg protocol.Message) bool {
+		correction, ok := msg.(*protocol.Correction)
+		return ok && correction.X == 3 && correction.Y == 3
+	})
+}
+
+func isRequest(from, to int, status protocol.RequestStatus) func(protocol.Message) bool {
+	return func(msg protocol.Message) bool {
+		req, ok := msg.(*protocol.Request)
+		return ok && req.From == from && req.To == to && req.Status == status
+	}
+}
+
+func TestScenarioRequests(t *testing.T) {
+	network := transport.NewMemory(5, flakyNetwork)
+	startTestServer(t, network, Config{})
+
+	alice := dialTestClient(t, network, "Alice", "")
+	bob := dialTestClient(t, network, "Bob", "")
+	carol := dialTestClient(t, network, "Carol", "")
+	alice.send(&protocol.PlayerState{Location: "test", X: 0, Y: 0})
+	bob.send(&protocol.PlayerState{Location: "test", X: 1, Y: 0})
+	carol.send(&protocol.PlayerState{Location: "test", X: 0, Y: 3})
+	alice.expect("Bob on the map", isStateAt(bob.welcome.Id, 1, 0))
+	bob.expect("Carol on the map", isStateAt(carol.welcome.Id, 0, 3))
+	aliceId, bobId, carolId := alice.welcome.Id, bob.welcome.Id, carol.welcome.Id
+
+	// Carol is out of reach
+	alice.send(&protocol.Request{To: carolId, Interaction: protocol.WaveInteraction, Status: protocol.RequestAsked})
+	alice.expect("Carol being unavailable", isRequest(aliceId, carolId, protocol.RequestUnavailable))
+
+	alice.send(&protocol.Request{To: bobId, Interaction: protocol.TradeInteraction, Status: protocol.RequestAsked})
+	bob.expect("Alice asking", isRequest(aliceId, bobId, protocol.RequestAsked))
+	alice.expect("the request being passed on", isRequest(aliceId, bobId, protocol.RequestAsked))
+
+	// Bob is busy until he has answered
+	carol.send(&protocol.PlayerState{Location: "test", X: 1, Y: 1})
+	bob.expect("Carol coming closer", isStateAt(carolId, 1, 1))
+	carol.send(&protocol.Request{To: bobId, Interaction: protocol.BattleInteraction, Status: protocol.RequestAsked})
+	carol.expect("Bob being busy", isRequest(carolId, bobId, protocol.RequestUnavailable))
+
+	bob.send(&protocol.Request{To: aliceId, Status: protocol.RequestAccepted})
+	alice.expect("Bob accepting", isRequest(aliceId, bobId, protocol.RequestAccepted))
+	bob.expect("Bob accepting", isRequest(aliceId, bobId, protocol.RequestAccepted))
+
+	// Requests die with the connection of the player that asked
+	carol.send(&protocol.Request{To: bobId, Interaction: protocol.BattleInteraction, Status: protocol.RequestAsked})
+	bob.expect("Carol asking", isRequest(carolId, bobId, protocol.RequestAsked))
+	carol.conn.Close()
+	bob.expect("the request expiring", isRequest(carolId, bobId, protocol.RequestExpired))
+}
+
+func TestScenarioRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recording")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "traffic.pokrec")
+
+	network := transport.NewMemory(7, flakyNetwork)
+	s := startTestServer(t, network, Config{RecordFile: path})
+
+	alice := dialTestClient(t, network, "Alice", "")
+	bob := dialTestClient(t, network, "Bob", "")
+	alice.send(&protocol.PlayerState{Location: "test", X: 0, Y: 0})
+	bob.send(&protocol.PlayerState{Location: "test", X: 3, Y: 0})
+	alice.send(&protocol.PlayerState{Location: "test", X: 1, Y: 0})
+	alice.send(&protocol.PlayerState{Location: "test", X: 1, Y: 2})
+	bob.expect("Alice moving", isStateAt(alice.welcome.Id, 1, 2))
+	s.Shutdown("Recording is done")
+
+	entries, err := recording.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replayed := transport.NewMemory(8, transport.Conditions{})
+	startTestServer(t, replayed, Config{})
+	carol := dialTestClient(t, replayed, "Carol", "")
+	carol.send(&protocol.PlayerState{Location: "test", X: 4, Y: 4})
+
+	opts := recording.Options{Speed: 4, Network: replayed}
+	if err := recording.ReplayToServer(entries, testAddr, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Carol came first, so Alice has a new id
+	carol.expect("the replayed Alice", func(msg protocol.Message) bool {
+		state, ok := msg.(*protocol.PlayerState)
+		return ok && state.Id != carol.welcome.Id && state.X == 1 && state.Y == 2
+	})
+}
+
+func isRejected(reason string) func(protocol.Message) bool {
+	return func(msg protocol.Message) bool {
+		reject, ok := msg.(*protocol.Reject)
+		return ok && reject.Reason == reason
+	}
+}
+
+func TestScenarioRooms(t *testing.T) {
+	network := transport.NewMemory(9, flakyNetwork)
+	s := startTestServer(t, network, Config{
+		MaxConnections: 2,
+		Rooms: []RoomConfig{{Name: "lobby"}, {Name: "arena", Capacity: 1}},
+	})
+
+	alice := dialTestClient(t, network, "Alice", "")
+	bob := dialTestRoom(t, network, "lobby", "Bob", "")
+	carol := dialTestRoom(t, network, "arena", "Carol", "")
+	alice.expect("Bob joining", isJoin(bob.welcome.Id))
+
+	_, msg := handshakeTestClient(t, network, &protocol.Hello{Version: protocol.Version, Name: "Dave", Password: "password", Room: "arena"})
+	if !isRejected("Room is full")(msg) {
+		t.Errorf("Joined a full room: %+v", msg)
+	}
+	_, msg = handshakeTestClient(t, network, &protocol.Hello{Version: protocol.Version, Name: "Dave", Password: "password", Room: "attic"})
+	if !isRejected("No room called attic")(msg) {
+		t.Errorf("Joined a room that does not exist: %+v", msg)
+	}
+
+	// Rooms share maps, but nothing else
+	alice.send(&protocol.PlayerState{Location: "test", X: 0, Y: 0})
+	carol.send(&protocol.PlayerState{Location: "test", X: 1, Y: 0})
+	bob.send(&protocol.PlayerState{Location: "test", X: 3, Y: 0})
+	bob.expect("Alice on the map", isStateAt(alice.welcome.Id, 0, 0))
+	carol.send(&protocol.Chat{Scope: protocol.GlobalChat, Text: "anyone?"})
+	carol.expect("her own chat", func(msg protocol.Message) bool {
+		_, ok := msg.(*protocol.Chat)
+		return ok
+	})
+	alice.send(&protocol.Request{To: carol.welcome.Id, Interaction: protocol.WaveInteraction, Status: protocol.RequestAsked})
+	alice.expect("Carol being unavailable", isRequest(alice.welcome.Id, carol.welcome.Id, protocol.RequestUnavailable))
+	alice.expectNone("anything from Carol", 200 * time.Millisecond, func(msg protocol.Message) bool {
+		switch m := msg.(type) {
+			case *protocol.Join:
+				return m.Id == carol.welcome.Id
+			case *protocol.PlayerState:
+				return m.Id == carol.welcome.Id
+			case *protocol.Chat:
+				return m.Id == carol.welcome.Id
+		}
+		return false
+	})
+
+	if err := s.SetTime("arena", 18, 30); err != nil {
+		t.Fatal(err)
+	}
+	carol.expect("the clock changing", func(msg protocol.Message) bool {
+		_, ok := msg.(*protocol.Clock)
+		return ok
+	})
+	bob.expectNone("the clock of the arena", 200 * time.Millisecond, func(msg protocol.Message) bool {
+		_, ok := msg.(*protocol.Clock)
+		return ok
+	})
+	if err := s.SetTime("attic", 18, 30); err != ErrNoRoom {
+		t.Errorf("Error %v not equal to %v", err, ErrNoRoom)
+	}
+
+	// Leaving makes room for someone else
+	carol.conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	s.Kick(carol.welcome.Id, "Testing")
+	dave := dialTestRoom(t, network, "arena", "Dave", "")
+	if rooms := s.Rooms(); rooms[1].Players != 1 {
+		t.Errorf("Players %d not equal to %d", rooms[1].Players, 1)
+	}
+	dave.expectNone("Alice joining", 200 * time.Millisecond, isJoin(alice.welcome.Id))
+}
@@ -0,0 +1,871 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/atemmel/pok/pkg/protocol"
+	"github.com/atemmel/pok/pkg/recording"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const handshakeTimeout = 5 * time.Second
+
+// How long a player may take to be told that the server is shutting down
+const goodbyeTimeout = time.Second
+
+type Message struct {
+	author net.Conn
+	contents protocol.Message
+}
+
+// session is what the server knows about a single connected player
+type session struct {
+	id int
+	name string
+	token string
+	room *room
+	location string
+	state *protocol.PlayerState	// nil until the first state has arrived
+	conn net.Conn	// nil while waiting for the player to resume
+	detachedAt time.Time
+	chat chatLimiter
+	moves moveTracker
+	udpKey uint64	// identifies datagrams from the player
+	udpAddr net.Addr	// nil until a datagram has arrived
+	udpSeq uint32	// of the newest datagram from the player
+	stateSeq uint32	// of the newest state passed on to others
+}
+
+// pendingConn is a connection that has completed its handshake
+type pendingConn struct {
+	conn net.Conn
+	hello *protocol.Hello
+	name string	// as registered, which may differ in case from hello.Name
+	room *room
+}
+
+// PlayerInfo is a snapshot of a session, for operators
+type PlayerInfo struct {
+	Id int
+	Name string
+	Room string
+	Location string
+	X, Y, Z int
+	Connected bool	// false while the server waits for the player to resume
+	Flagged bool	// for repeated illegal moves
+}
+
+type Server struct {
+	conf Config
+	listener net.Listener
+	web *http.Server	// nil unless websockets are accepted
+	udp net.PacketConn	// nil unless player states are accepted over udp
+	conns map[net.Conn] *session
+	sessions map[string] *session	// by token, attached or not
+	connsMutex sync.Mutex	// guards listener, web, udp, conns and sessions
+	newConn chan pendingConn
+	deadConn chan net.Conn
+	messageChan chan Message
+	quit chan struct{}
+	quitOnce sync.Once
+	done chan struct{}	// closed once Shutdown has finished
+	idGen int
+	filter chatFilter
+	accounts *accountStore
+	players *playerStore
+	rooms []*room	// in the order of the config, never changed after NewServer
+	maps map[string]*mapData	// by location, guarded by connsMutex
+	udpSessions map[uint64]*session	// by udp key, guarded by connsMutex
+	requests map[int]*pendingRequest	// by the id of the player asked, guarded by connsMutex
+	admin *http.Server	// nil unless admin requests are served, guarded by connsMutex
+	startedAt time.Time
+	traffic traffic	// of every connection, and udp
+	rates trafficRates	// guarded by connsMutex
+	recorder *recording.Recorder	// nil unless recording
+	connIds uint32	// the id of the newest connection, only accessed atomically
+}
+
+func NewServer(conf Config) (*Server, error) {
+	conf.setDefaults()
+	accounts, err := loadAccounts(conf.AccountsFile, conf.ClosedRegistration)
+	if err != nil {
+		return nil, err
+	}
+
+	players, err := loadPlayers(conf.PlayersFile)
+	if err != nil {
+		return nil, err
+	}
+
+	rooms, err := newRooms(conf.Rooms, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	var recorder *recording.Recorder
+	if conf.RecordFile != "" {
+		recorder, err = recording.Create(conf.RecordFile)
+		if err != nil {
+			return nil, err
+		}
+		log.Println("Recording traffic to", conf.RecordFile)
+	}
+
+	return &Server {
+		conf,
+		nil,
+		nil,
+		nil,
+		make(map[net.Conn]*session),
+		make(map[string]*session),
+		sync.Mutex{},
+		make(chan pendingConn),
+		make(chan net.Conn),
+		make(chan Message),
+		make(chan struct{}),
+		sync.Once{},
+		make(chan struct{}),
+		0,
+		newChatFilter(conf.ChatFilter),
+		accounts,
+		players,
+		rooms,
+		make(map[string]*mapData),
+		make(map[uint64]*session),
+		make(map[int]*pendingRequest),
+		nil,
+		time.Now(),
+		traffic{},
+		trafficRates{},
+		recorder,
+		0,
+	}, nil
+}
+
+// Serve listens for players until Shutdown is called
+func (s *Server) Serve() error {
+	log.Println("Starting server...")
+	listener, err := s.conf.Network.Listen(s.conf.Url + ":" + s.conf.Port)
+	if err != nil {
+		return err
+	}
+
+	s.connsMutex.Lock()
+	s.listener = listener
+	s.connsMutex.Unlock()
+
+	log.Println("Server is now running on", listener.Addr())
+
+	if s.conf.WebSocketPort != "" {
+		if err := s.listenWebSocket(); err != nil {
+			listener.Close()
+			return err
+		}
+	}
+
+	if s.conf.UdpPort != "" {
+		if err := s.listenUdp(); err != nil {
+			listener.Close()
+			return err
+		}
+	}
+
+	if s.conf.AdminAddr != "" {
+		if err := s.listenAdmin(); err != nil {
+			listener.Close()
+			return err
+		}
+	}
+
+	go s.acceptConnections()
+
+	reaper := time.NewTicker(time.Second)
+	defer reaper.Stop()
+
+	for {
+		select {
+			case pending := <-s.newConn:
+				s.attach(pending)
+			case conn := <-s.deadConn:
+				s.detach(conn)
+			case message := <-s.messageChan:
+				s.route(message)
+			case now := <-reaper.C:
+				s.reapSessions(now)
+			case <-s.quit:
+				// Players are saved and the recording is closed before
+				// returning, so nothing is lost if the process exits
+				<-s.done
+				log.Println("Server stopped")
+				return nil
+		}
+	}
+}
+
+// Shutdown tells every connected player why, hangs up on them and stops
+// listening for new ones
+func (s *Server) Shutdown(reason string) {
+	s.quitOnce.Do(func() {
+		log.Println("Shutting down:", reason)
+		close(s.quit)
+
+		s.connsMutex.Lock()
+		defer s.connsMutex.Unlock()
+
+		if s.listener != nil {
+			s.listener.Close()
+		}
+		if s.web != nil {
+			s.web.Close()
+		}
+		if s.udp != nil {
+			s.udp.Close()
+		}
+		if s.admin != nil {
+			s.admin.Close()
+		}
+
+		bytes, _ := protocol.Encode(&protocol.Announcement{Text: reason})
+		for c := range s.conns {
+			c.SetWriteDeadline(time.Now().Add(goodbyeTimeout))
+			c.Write(bytes)
+			c.Close()
+		}
+		s.players.flush(time.Now(), true)
+		if s.recorder != nil {
+			s.recorder.Close()
+		}
+		close(s.done)
+	})
+}
+
+func (s *Server) stopping() bool {
+	select {
+		case <-s.quit:
+			return true
+		default:
+			return false
+	}
+}
+
+func (s *Server) acceptConnections() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if s.stopping() {
+				return
+			}
+			log.Println(err)
+			continue
+		}
+
+		s.admit(conn)
+	}
+}
+
+// admit starts the handshake with a new connection. Whether there is room
+// for it is only known once it has said which room it wants to join.
+func (s *Server) admit(conn net.Conn) {
+	go s.handshake(s.wrap(conn))
+}
+
+// handshake waits for the clients Hello and only hands the connection over
+// to the main loop if the client speaks the same protocol version, and asks
+// for a room that exists
+func (s *Server) handshake(conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	msg, err := protocol.ReadMessage(conn)
+	conn.SetReadDeadline(time.Time{})
+
+	if err != nil {
+		log.Println("Handshake with", conn.RemoteAddr(), "failed:", err)
+		conn.Close()
+		return
+	}
+
+	hello, ok := msg.(*protocol.Hello)
+	if ok {
+		// Passwords stay out of recordings
+		redacted := *hello
+		redacted.Password = ""
+		s.record(conn, recording.Inbound, false, &redacted)
+	} else {
+		s.record(conn, recording.Inbound, false, msg)
+	}
+	if !ok {
+		log.Println("Expected hello from", conn.RemoteAddr(), "but recieved kind", msg.Kind())
+		conn.Close()
+		return
+	}
+
+	if hello.Version != protocol.Version {
+		log.Println("Client", conn.RemoteAddr(), "uses protocol version", hello.Version, "expected", protocol.Version)
+		protocol.WriteMessage(conn, &protocol.Reject{Reason: "Protocol version mismatch"})
+		conn.Close()
+		return
+	}
+
+	r, err := s.room(hello.Room)
+	if err != nil {
+		log.Println("Client", conn.RemoteAddr(), "asked for unknown room", hello.Room)
+		protocol.WriteMessage(conn, &protocol.Reject{Reason: "No room called " + hello.Room})
+		conn.Close()
+		return
+	}
+
+	name, err := s.accounts.login(hello.Name, hello.Password)
+	if err != nil {
+		log.Println("Client", conn.RemoteAddr(), "could not log in as", hello.Name, ":", err)
+		protocol.WriteMessage(conn, &protocol.Reject{Reason: err.Error()})
+		conn.Close()
+		return
+	}
+
+	select {
+		case s.newConn <- pendingConn{conn, hello, name, r}:
+		case <-s.quit:
+			conn.Close()
+	}
+}
+
+// attach binds a connection to the session named by its token, or to a
+// brand new session if there is no such session in the room it asked for.
+// New sessions are turned away if the room is full.
+func (s *Server) attach(pending pendingConn) {
+	s.connsMutex.Lock()
+	sess, resumed := s.sessions[pending.hello.Token]
+	if resumed && (sess.name != pending.name || sess.room != pending.room) {
+		resumed = false
+	}
+
+	if resumed && sess.conn != nil {
+		// The old connection has not been noticed as dead yet
+		delete(s.conns, sess.conn)
+		sess.conn.Close()
+	} else if !resumed {
+		// Only one session per account, the newest login wins. The old
+		// session only makes room for the new one if they share a room.
+		old := s.sessionByName(pending.name)
+		population := s.population(pending.room)
+		if old != nil && old.room == pending.room {
+			population--
+		}
+		if population >= pending.room.capacity {
+			s.connsMutex.Unlock()
+			log.Println("Room", pending.room.name, "is full, turning away", pending.name)
+			protocol.WriteMessage(pending.conn, &protocol.Reject{Reason: "Room is full"})
+			pending.conn.Close()
+			return
+		}
+		if old != nil {
+			log.Println(old.name, "logged in again, dropping session with id", old.id)
+			s.drop(old, "Logged in from another location")
+		}
+	}
+	s.connsMutex.Unlock()
+
+	if resumed {
+		log.Println("Connection with id", sess.id, "resumed by", sess.name)
+		s.resume(pending.conn, sess)
+	} else {
+		log.Println("New connection with id", s.idGen, "for", pending.name, "in room", pending.room.name)
+		sess = s.designate(pending.conn, s.idGen, pending.name, pending.room, pending.hello.Spawn)
+		s.idGen++
+	}
+
+	go s.readClient(pending.conn, sess.id)
+}
+
+func (s *Server) readClient(conn net.Conn, id int) {
+	timeout := time.Duration(s.conf.Timeout) * time.Millisecond
+	for {
+		// Clients send heartbeats when idle, so silence means they are gone
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		msg, err := protocol.ReadMessage(conn)
+		if errors.Is(err, protocol.ErrMalformed) {
+			log.Println("Ill-formed message recieved from", id, ":", err)
+			continue
+		} else if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				log.Println("Connection with id", id, "timed out")
+			} else if err != io.EOF && !s.stopping() {
+				log.Println("Could not read from", id, ":", err)
+			}
+			break
+		}
+		s.traffic.received(0, 1)
+		s.record(conn, recording.Inbound, false, msg)
+
+		switch m := msg.(type) {
+			case *protocol.Heartbeat:
+				// Nothing to do, the deadline has already been pushed
+			case *protocol.PlayerState:
+				m.Id = id
+				s.send(Message{conn, m})
+			case *protocol.WorldEvent:
+				m.Id = id
+				s.send(Message{conn, m})
+			case *protocol.Chat:
+				m.Id = id
+				s.send(Message{conn, m})
+			case *protocol.Request:
+				m.From = id
+				s.send(Message{conn, m})
+			default:
+				log.Println("Unexpected message of kind", msg.Kind(), "recieved from", id)
+		}
+	}
+
+	s.recordClose(conn)
+	select {
+		case s.deadConn <- conn:
+		case <-s.quit:
+	}
+}
+
+func (s *Server) send(message Message) {
+	select {
+		case s.messageChan <- message:
+		case <-s.quit:
+	}
+}
+
+func newToken() string {
+	bytes := make([]byte, 16)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(bytes)
+}
+
+// welcomeSpawn leaves out where a player spawns unless the client asked to
+// be moved there. Moves are checked from the spawn either way.
+func welcomeSpawn(spawn protocol.Spawn, wanted bool) protocol.Spawn {
+	if !wanted {
+		return protocol.Spawn{}
+	}
+	return spawn
+}
+
+func (s *Server) designate(conn net.Conn, id int, name string, r *room, wantsSpawn bool) *session {
+	s.connsMutex.Lock()
+	defer s.connsMutex.Unlock()
+
+	sess := &session{id: id, name: name, token: newToken(), room: r, conn: conn, udpKey: newUdpKey()}
+	spawn, ok := s.spawn(name, r)
+	if ok {
+		// Moves are checked from here, so a client that sends a state from
+		// somewhere else before spawning is put right by a correction
+		sess.state = &protocol.PlayerState{
+			Id: id,
+			Location: spawn.Location,
+			X: spawn.X,
+			Y: spawn.Y,
+			Z: spawn.Z,
+			Avatar: protocol.Avatar{Mode: spawn.Mode, Facing: spawn.Facing},
+		}
+	}
+
+	protocol.WriteMessage(conn, &protocol.Welcome{
+		Id: id,
+		Token: sess.token,
+		Name: name,
+		UdpPort: s.udpPort(),
+		UdpKey: sess.udpKey,
+		Spawn: welcomeSpawn(spawn, wantsSpawn),
+	})
+	protocol.WriteMessage(conn, r.clock.message(time.Now()))
+
+	// Let the newcomer and everyone else in the room know about each other
+	join, _ := protocol.Encode(&protocol.Join{Id: id, Name: name})
+	for _, other := range s.sessions {
+		if other.room != r {
+			continue
+		}
+		protocol.WriteMessage(conn, &protocol.Join{Id: other.id, Name: other.name})
+		if other.conn != nil {
+			other.conn.Write(join)
+		}
+	}
+
+	s.conns[conn] = sess
+	s.sessions[sess.token] = sess
+	s.udpSessions[sess.udpKey] = sess
+	return sess
+}
+
+// resume hands a session over to a new connection. Since the other players
+// never learned that it went away, only the returning client has to be
+// brought up to speed.
+func (s *Server) resume(conn net.Conn, sess *session) {
+	s.connsMutex.Lock()
+	defer s.connsMutex.Unlock()
+
+	protocol.WriteMessage(conn, &protocol.Welcome{
+		Id: sess.id,
+		Token: sess.token,
+		Name: sess.name,
+		UdpPort: s.udpPort(),
+		UdpKey: sess.udpKey,
+	})
+	protocol.WriteMessage(conn, sess.room.clock.message(time.Now()))
+
+	for _, other := range s.sessions {
+		if other == sess || other.room != sess.room {
+			continue
+		}
+		protocol.WriteMessage(conn, &protocol.Join{Id: other.id, Name: other.name})
+		if sess.location != "" && other.location == sess.location {
+			protocol.WriteMessage(conn, &protocol.EnterMap{Id: other.id, Location: sess.location})
+			if other.state != nil {
+				protocol.WriteMessage(conn, other.state)
+			}
+		}
+	}
+
+	if sess.location != "" {
+		s.sendWorldState(conn, sess.room, sess.location)
+		s.sendWeather(conn, sess.room, sess.location)
+	}
+
+	sess.conn = conn
+	s.conns[conn] = sess
+}
+
+// route forwards a message to the players that share a map with its author
+func (s *Server) route(message Message) {
+	s.connsMutex.Lock()
+	sess := s.conns[message.author]
+	if sess == nil {
+		s.connsMutex.Unlock()
+		return
+	}
+
+	switch m := message.contents.(type) {
+		case *protocol.PlayerState:
+			if !s.acceptMove(message.author, sess, m) {
+				s.connsMutex.Unlock()
+				return
+			}
+			if m.Location != sess.location {
+				s.changeMap(message.author, sess, m.Location)
+			}
+			sess.state = m
+			sess.stateSeq++
+			s.players.set(sess.name, m)
+		case *protocol.WorldEvent:
+			if m.Location != sess.location || !sess.room.world(m.Location).apply(m) {
+				s.connsMutex.Unlock()
+				return
+			}
+		case *protocol.Chat:
+			s.chat(sess, m)
+			s.connsMutex.Unlock()
+			return
+		case *protocol.Request:
+			s.request(sess, m)
+			s.connsMutex.Unlock()
+			return
+	}
+
+	r, location := sess.room, sess.location
+	s.connsMutex.Unlock()
+
+	if location != "" {
+		s.broadcastToMap(message, r, location)
+	}
+}
+
+// changeMap moves sess to another map, making the players it leaves behind
+// forget about it, and the players it joins aware of it (and vice versa).
+// Assumes that connsMutex is held.
+func (s *Server) changeMap(conn net.Conn, sess *session, to string) {
+	from := sess.location
+	log.Println("Player", sess.id, "moved from", from, "to", to)
+
+	leave, _ := protocol.Encode(&protocol.LeaveMap{Id: sess.id, Location: from})
+	enter, _ := protocol.Encode(&protocol.EnterMap{Id: sess.id, Location: to})
+
+	for _, other := range s.sessions {
+		if other == sess || other.room != sess.room || other.location == "" {
+			continue
+		}
+
+		if other.location == from {
+			if other.conn != nil {
+				other.conn.Write(leave)
+			}
+			protocol.WriteMessage(conn, &protocol.LeaveMap{Id: other.id, Location: from})
+		} else if other.location == to {
+			if other.conn != nil {
+				other.conn.Write(enter)
+			}
+			protocol.WriteMessage(conn, &protocol.EnterMap{Id: other.id, Location: to})
+			if other.state != nil {
+				protocol.WriteMessage(conn, other.state)
+			}
+		}
+	}
+
+	sess.location = to
+	if to != "" {
+		s.sendWorldState(conn, sess.room, to)
+		s.sendWeather(conn, sess.room, to)
+	}
+}
+
+// chat passes a message on to everyone on the same map, or everyone in the
+// same room, once it has been cleaned up. Unlike other messages, chat is
+// echoed back to its author so that they see the same text as everyone else.
+// Assumes that connsMutex is held.
+func (s *Server) chat(sess *session, chat *protocol.Chat) {
+	ok, muted := sess.chat.allow(time.Now())
+	if muted {
+		log.Println("Player", sess.id, "was muted for flooding")
+		s.tell(sess, "You have been muted for sending too many messages.")
+	}
+	if !ok {
+		return
+	}
+
+	text := sanitizeChat(chat.Text)
+	if text == "" {
+		return
+	}
+
+	out := &protocol.Chat{Id: sess.id, Scope: chat.Scope, Text: s.filter.censor(text)}
+	switch out.Scope {
+		case protocol.MapChat:
+			if sess.location == "" {
+				return
+			}
+		case protocol.GlobalChat:
+		default:
+			log.Println("Player", sess.id, "sent chat with invalid scope", chat.Scope)
+			return
+	}
+
+	log.Printf("[chat] %s: %s\n", sess.name, out.Text)
+	bytes, err := protocol.Encode(out)
+	if err != nil {
+		log.Println("Could not encode chat:", err)
+		return
+	}
+
+	for c, other := range s.conns {
+		if other.room == sess.room && (out.Scope == protocol.GlobalChat || other.location == sess.location) {
+			c.Write(bytes)
+		}
+	}
+}
+
+// tell sends a message from the server to a single player.
+// Assumes that connsMutex is held.
+func (s *Server) tell(sess *session, text string) {
+	if sess.conn != nil {
+		protocol.WriteMessage(sess.conn, &protocol.Chat{Id: -1, Scope: protocol.SystemChat, Text: text})
+	}
+}
+
+// broadcastToMap passes a message on to everyone else on a map in a room.
+// Player states go over udp to those that use it, as a lost state is soon
+// replaced by a newer one anyway.
+func (s *Server) broadcastToMap(message Message, r *room, location string) {
+	bytes, err := protocol.Encode(message.contents)
+	if err != nil {
+		log.Println("Could not encode message:", err)
+		return
+	}
+
+	s.connsMutex.Lock()
+	defer s.connsMutex.Unlock()
+
+	var seq uint32
+	_, isState := message.contents.(*protocol.PlayerState)
+	if author, ok := s.conns[message.author]; ok && isState {
+		seq = author.stateSeq
+	}
+
+	for c, other := range s.conns {
+		if c == message.author || other.room != r || other.location != location {
+			continue
+		}
+		if isState && s.sendDatagram(other, seq, message.contents) {
+			continue
+		}
+		c.Write(bytes)
+	}
+}
+
+// detach keeps the session of a dead connection around, so that the player
+// can resume it within the grace period of the config
+func (s *Server) detach(conn net.Conn) {
+	s.connsMutex.Lock()
+	defer s.connsMutex.Unlock()
+
+	conn.Close()
+	sess, ok := s.conns[conn]
+	if !ok {
+		// Already replaced by a resumed connection, or kicked
+		return
+	}
+
+	log.Println("Connection with id", sess.id, "died, holding on to its session")
+	delete(s.conns, conn)
+	sess.conn = nil
+	sess.udpAddr = nil
+	sess.detachedAt = time.Now()
+	// It cannot answer, or be answered, while it is away
+	s.expireRequests(sess.detachedAt, sess)
+}
+
+func (s *Server) reapSessions(now time.Time) {
+	s.connsMutex.Lock()
+	defer s.connsMutex.Unlock()
+
+	grace := time.Duration(s.conf.ResumeGrace) * time.Millisecond
+	for token, sess := range s.sessions {
+		if sess.conn == nil && now.Sub(sess.detachedAt) >= grace {
+			log.Println("Session with id", sess.id, "expired")
+			delete(s.sessions, token)
+			s.disconnect(sess)
+		}
+	}
+	s.expireRequests(now, nil)
+	s.players.flush(now, false)
+	s.rates.sample(now, s.traffic.load())
+	if s.recorder != nil {
+		if err := s.recorder.Flush(); err != nil {
+			log.Println("Could not record traffic, recording stopped:", err)
+			s.recorder.Close()
+		}
+	}
+}
+
+// disconnect lets everyone in its room know that a player is gone for good,
+// and saves where it left off. Assumes that connsMutex is held.
+func (s *Server) disconnect(sess *session) {
+	delete(s.udpSessions, sess.udpKey)
+	s.expireRequests(time.Now(), sess)
+	s.players.flush(time.Now(), true)
+	bytes, _ := protocol.Encode(&protocol.Leave{Id: sess.id})
+
+	for c, other := range s.conns {
+		if other.room != sess.room {
+			continue
+		}
+		log.Println("Sending kill message from", sess.id, "to", other.id)
+		c.Write(bytes)
+	}
+	log.Println("Kill message sent")
+}
+
+func (s *Server) Players() []PlayerInfo {
+	s.connsMutex.Lock()
+	defer s.connsMutex.Unlock()
+
+	players := make([]PlayerInfo, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		players = append(players, sess.info())
+	}
+	return players
+}
+
+func (sess *session) info() PlayerInfo {
+	info := PlayerInfo{
+		Id: sess.id,
+		Name: sess.name,
+		Room: sess.room.name,
+		Location: sess.location,
+		Connected: sess.conn != nil,
+		Flagged: sess.moves.flagged,
+	}
+	if sess.state != nil {
+		info.X, info.Y, info.Z = sess.state.X, sess.state.Y, sess.state.Z
+	}
+	return info
+}
+
+// FindPlayer looks a player up by id, or by name if who is not a number
+func (s *Server) FindPlayer(who string) (int, bool) {
+	if id, err := strconv.Atoi(who); err == nil {
+		return id, true
+	}
+
+	s.connsMutex.Lock()
+	defer s.connsMutex.Unlock()
+	if sess := s.sessionByName(who); sess != nil {
+		return sess.id, true
+	}
+	return 0, false
+}
+
+// Kick disconnects a player without giving it the chance to resume.
+// Returns false if there is no player with that id.
+func (s *Server) Kick(id int, reason string) bool {
+	s.connsMutex.Lock()
+	defer s.connsMutex.Unlock()
+
+	sess := s.sessionById(id)
+	if sess == nil {
+		return false
+	}
+	s.drop(sess, reason)
+	return true
+}
+
+// drop ends a session for good, telling its player why.
+// Assumes that connsMutex is held.
+func (s *Server) drop(sess *session, reason string) {
+	if sess.conn != nil {
+		protocol.WriteMessage(sess.conn, &protocol.Kick{Reason: reason})
+		delete(s.conns, sess.conn)
+		sess.conn.Close()
+	}
+	delete(s.sessions, sess.token)
+	s.disconnect(sess)
+}
+
+// Assumes that connsMutex is held
+func (s *Server) sessionById(id int) *session {
+	for _, sess := range s.sessions {
+		if sess.id == id {
+			return sess
+		}
+	}
+	return nil
+}
+
+// Assumes that connsMutex is held
+func (s *Server) sessionByName(name string) *session {
+	for _, sess := range s.sessions {
+		if strings.EqualFold(sess.name, name) {
+			return sess
+		}
+	}
+	return nil
+}
+
+// Announce shows a message to every connected player
+func (s *Server) Announce(text string) {
+	bytes, err := protocol.Encode(&protocol.Announcement{Text: text})
+	if err != nil {
+		log.Println("Could not encode announcement:", err)
+		return
+	}
+
+	s.connsMutex.Lock()
+	for c := range s.conns {
+		c.Write(bytes)
+	}
+	s.connsMutex.Unlock()
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"github.com/atemmel/pok/pkg/recording"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// How far back message and byte rates look
+const rateWindow = time.Minute
+
+// traffic counts what went over one or more connections. Only accessed
+// atomically.
+type traffic struct {
+	bytesIn uint64
+	bytesOut uint64
+	messagesIn uint64
+	messagesOut uint64
+}
+
+func (t *traffic) received(bytes, messages int) {
+	atomic.AddUint64(&t.bytesIn, uint64(bytes))
+	atomic.AddUint64(&t.messagesIn, uint64(messages))
+}
+
+func (t *traffic) sent(bytes, messages int) {
+	atomic.AddUint64(&t.bytesOut, uint64(bytes))
+	atomic.AddUint64(&t.messagesOut, uint64(messages))
+}
+
+func (t *traffic) load() traffic {
+	return traffic{
+		atomic.LoadUint64(&t.bytesIn),
+		atomic.LoadUint64(&t.bytesOut),
+		atomic.LoadUint64(&t.messagesIn),
+		atomic.LoadUint64(&t.messagesOut),
+	}
+}
+
+// countingConn counts the bytes that go over a connection, both for the
+// connection itself and for the server as a whole, and records what is
+// written to it if the server is recording. Every write is a single
+// message, as messages are always encoded before they are written.
+type countingConn struct {
+	net.Conn
+	id uint32	// in recordings
+	own traffic
+	total *traffic
+	recorder *recording.Recorder	// nil unless recording
+	connectedAt time.Time
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.own.received(n, 0)
+	c.total.received(n, 0)
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.own.sent(n, 1)
+	c.total.sent(n, 1)
+	if c.recorder != nil {
+		c.recorder.Record(time.Now(), c.id, recording.Outbound, false, b)
+	}
+	return n, err
+}
+
+type rateSample struct {
+	at time.Time
+	total uint64
+}
+
+// rateMeter turns a running total into a rate over the last rateWindow
+type rateMeter struct {
+	samples []rateSample
+}
+
+func (m *rateMeter) sample(now time.Time, total uint64) {
+	m.samples = append(m.samples, rateSample{now, total})
+	old := 0
+	for old < len(m.samples) - 2 && now.Sub(m.samples[old + 1].at) >= rateWindow {
+		old++
+	}
+	m.samples = m.samples[old:]
+}
+
+// rate returns the change per second, or 0 until there are two samples
+func (m *rateMeter) rate() float64 {
+	if len(m.samples) < 2 {
+		return 0
+	}
+	first, last := m.samples[0], m.samples[len(m.samples) - 1]
+	return float64(last.total - first.total) / last.at.Sub(first.at).Seconds()
+}
+
+// trafficRates follow the counters of a traffic
+type trafficRates struct {
+	bytesIn rateMeter
+	bytesOut rateMeter
+	messagesIn rateMeter
+	messagesOut rateMeter
+}
+
+func (r *trafficRates) sample(now time.Time, t traffic) {
+	r.bytesIn.sample(now, t.bytesIn)
+	r.bytesOut.sample(now, t.bytesOut)
+	r.messagesIn.sample(now, t.messagesIn)
+	r.messagesOut.sample(now, t.messagesOut)
+}
+
+// PlayerStatus is a PlayerInfo along with what the player has sent and
+// received over its current connection
+type PlayerStatus struct {
+	PlayerInfo
+	BytesIn, BytesOut uint64
+	BytesInPerSecond, BytesOutPerSecond float64	// since it connected
+}
+
+// Status is a snapshot of the whole server, for monitoring
+type Status struct {
+	Uptime float64	// in seconds
+	Players []PlayerStatus
+	MessagesIn, MessagesOut uint64
+	MessagesInPerSecond, MessagesOutPerSecond float64	// over the last minute
+	BytesIn, BytesOut uint64
+	BytesInPerSecond, BytesOutPerSecond float64	// over the last minute
+	Rooms []RoomStatus
+}
+
+// Status gathers everything that monitoring may want to know
+func (s *Server) Status() Status {
+	s.connsMutex.Lock()
+	defer s.connsMutex.Unlock()
+
+	now := time.Now()
+	t := s.traffic.load()
+	status := Status{
+		Uptime: now.Sub(s.startedAt).Seconds(),
+		Players: make([]PlayerStatus, 0, len(s.sessions)),
+		MessagesIn: t.messagesIn,
+		MessagesOut: t.messagesOut,
+		MessagesInPerSecond: s.rates.messagesIn.rate(),
+		MessagesOutPerSecond: s.rates.messagesOut.rate(),
+		BytesIn: t.bytesIn,
+		BytesOut: t.bytesOut,
+		BytesInPerSecond: s.rates.bytesIn.rate(),
+		BytesOutPerSecond: s.rates.bytesOut.rate(),
+		Rooms: s.roomStatus(now),
+	}
+
+	for _, sess := range s.sessions {
+		player := PlayerStatus{PlayerInfo: sess.info()}
+		if conn, ok := sess.conn.(*countingConn); ok {
+			own := conn.own.load()
+			player.BytesIn, player.BytesOut = own.bytesIn, own.bytesOut
+			if seconds := now.Sub(conn.connectedAt).Seconds(); seconds > 0 {
+				player.BytesInPerSecond = float64(own.bytesIn) / seconds
+				player.BytesOutPerSecond = float64(own.bytesOut) / seconds
+			}
+		}
+		status.Players = append(status.Players, player)
+	}
+	return status
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"github.com/atemmel/pok/pkg/protocol"
+	"github.com/atemmel/pok/pkg/recording"
+	"log"
+	"net"
+	"strconv"
+)
+
+func newUdpKey() uint64 {
+	var bytes [8]byte
+	if _, err := rand.Read(bytes[:]); err != nil {
+		panic(err)
+	}
+	return binary.BigEndian.Uint64(bytes[:])
+}
+
+func (s *Server) listenUdp() error {
+	conn, err := s.conf.Network.ListenPacket(s.conf.Url + ":" + s.conf.UdpPort)
+	if err != nil {
+		return err
+	}
+
+	s.connsMutex.Lock()
+	s.udp = conn
+	s.connsMutex.Unlock()
+
+	log.Println("Accepting player states over udp on", conn.LocalAddr())
+	go s.readDatagrams(conn)
+	return nil
+}
+
+// readDatagrams passes player states that arrive over udp on to the main
+// loop, as if they had arrived over the tcp connection of their session.
+// Datagrams that arrive after a newer one from the same player are dropped.
+func (s *Server) readDatagrams(conn net.PacketConn) {
+	buf := make([]byte, protocol.MaxDatagramSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if s.stopping() {
+				return
+			}
+			log.Println("Could not read datagram:", err)
+			continue
+		}
+
+		s.traffic.received(n, 0)
+		key, seq, msg, err := protocol.DecodeDatagram(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		s.connsMutex.Lock()
+		sess := s.udpSessions[key]
+		if sess == nil || sess.conn == nil || !protocol.Newer(seq, sess.udpSeq) {
+			s.connsMutex.Unlock()
+			continue
+		}
+		sess.udpSeq = seq
+		sess.udpAddr = addr
+		author, id := sess.conn, sess.id
+		if conn, ok := author.(*countingConn); ok {
+			conn.own.received(n, 0)
+		}
+		s.connsMutex.Unlock()
+		s.traffic.received(0, 1)
+		s.record(author, recording.Inbound, true, msg)
+
+		switch m := msg.(type) {
+			case *protocol.Heartbeat:
+				// Only sent to let us know where to send states
+			case *protocol.PlayerState:
+				m.Id = id
+				s.send(Message{author, m})
+			default:
+				log.Println("Unexpected datagram of kind", msg.Kind(), "recieved from", id)
+		}
+	}
+}
+
+// udpPort is the port that clients should send datagrams to, or 0 if the
+// server does not accept any. Assumes that connsMutex is held.
+func (s *Server) udpPort() int {
+	if s.udp == nil {
+		return 0
+	}
+	_, port, err := net.SplitHostPort(s.udp.LocalAddr().String())
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+// sendDatagram sends a message to a player over udp, if it has told us
+// where to. Assumes that connsMutex is held.
+func (s *Server) sendDatagram(sess *session, seq uint32, msg protocol.Message) bool {
+	if s.udp == nil || sess.udpAddr == nil {
+		return false
+	}
+
+	datagram, err := protocol.EncodeDatagram(sess.udpKey, seq, msg)
+	if err != nil {
+		return false
+	}
+	n, _ := s.udp.WriteTo(datagram, sess.udpAddr)
+	s.record(sess.conn, recording.Outbound, true, msg)
+	s.traffic.sent(n, 1)
+	if conn, ok := sess.conn.(*countingConn); ok {
+		conn.own.sent(n, 0)
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"github.com/atemmel/pok/pkg/protocol"
+	"net"
+	"strings"
+)
+
+var weatherNames = []string{
+	protocol.ClearWeather: "clear",
+	protocol.HailWeather: "hail",
+	protocol.RainWeather: "rain",
+}
+
+func WeatherName(weather protocol.WeatherType) string {
+	if int(weather) < len(weatherNames) {
+		return weatherNames[weather]
+	}
+	return "unknown"
+}
+
+func ParseWeather(name string) (protocol.WeatherType, bool) {
+	for i, n := range weatherNames {
+		if strings.EqualFold(n, name) {
+			return protocol.WeatherType(i), true
+		}
+	}
+	return protocol.ClearWeather, false
+}
+
+// weatherOf returns the weather on a map in a room, which is whatever the
+// map file says unless it has been changed since.
+// Assumes that connsMutex is held.
+func (s *Server) weatherOf(r *room, location string) protocol.WeatherType {
+	if weather, ok := r.weather[location]; ok {
+		return weather
+	}
+	if m, err := s.mapData(location); err == nil {
+		return protocol.WeatherType(m.WeatherKind)
+	}
+	return protocol.ClearWeather
+}
+
+// Assumes that connsMutex is held
+func (s *Server) sendWeather(conn net.Conn, r *room, location string) {
+	protocol.WriteMessage(conn, &protocol.Weather{Location: location, Weather: s.weatherOf(r, location)})
+}
+
+// SetWeather changes the weather on a map in a room for everyone on it
+func (s *Server) SetWeather(roomName, location string, weather protocol.WeatherType) error {
+	s.connsMutex.Lock()
+	defer s.connsMutex.Unlock()
+
+	r, err := s.room(roomName)
+	if err != nil {
+		return err
+	}
+	if _, err := s.mapData(location); err != nil {
+		return err
+	}
+	r.weather[location] = weather
+
+	bytes, err := protocol.Encode(&protocol.Weather{Location: location, Weather: weather})
+	if err != nil {
+		return err
+	}
+	for c, sess := range s.conns {
+		if sess.room == r && sess.location == location {
+			c.Write(bytes)
+		}
+	}
+	return nil
+}
+
+// Weather lists every map in a room that has had its weather changed
+func (s *Server) Weather(roomName string) (map[string]protocol.WeatherType, error) {
+	s.connsMutex.Lock()
+	defer s.connsMutex.Unlock()
+
+	r, err := s.room(roomName)
+	if err != nil {
+		return nil, err
+	}
+	weather := make(map[string]protocol.WeatherType, len(r.weather))
+	for location, w := range r.weather {
+		weather[location] = w
+	}
+	return weather, nil
+}
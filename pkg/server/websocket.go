@@ -0,0 +1,43 @@
+package server
+
+import (
+	"github.com/atemmel/pok/pkg/websocket"
+	"log"
+	"net/http"
+)
+
+// WebSocketHandler accepts players over websockets. The frames are the same
+// as over tcp, carried in binary websocket messages.
+func (s *Server) WebSocketHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			log.Println("Websocket handshake with", r.RemoteAddr, "failed:", err)
+			return
+		}
+		s.admit(conn)
+	})
+}
+
+func (s *Server) listenWebSocket() error {
+	listener, err := s.conf.Network.Listen(s.conf.Url + ":" + s.conf.WebSocketPort)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(s.conf.WebSocketPath, s.WebSocketHandler())
+	web := &http.Server{Handler: mux}
+
+	s.connsMutex.Lock()
+	s.web = web
+	s.connsMutex.Unlock()
+
+	log.Println("Accepting websockets on", listener.Addr(), "at", s.conf.WebSocketPath)
+	go func() {
+		if err := web.Serve(listener); err != http.ErrServerClosed {
+			log.Println("Websocket listener stopped:", err)
+		}
+	}()
+	return nil
+}
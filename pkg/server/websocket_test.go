@@ -0,0 +1,55 @@
+package server
+
+import (
+	"github.com/atemmel/pok/pkg/protocol"
+	"github.com/atemmel/pok/pkg/websocket"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebSocketLogin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pokserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServer(Config{
+		Url: "127.0.0.1",
+		Port: "0",
+		AccountsFile: filepath.Join(dir, "accounts.json"),
+		PlayersFile: filepath.Join(dir, "players.json"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve()
+	defer s.Shutdown("Test is over")
+
+	web := httptest.NewServer(s.WebSocketHandler())
+	defer web.Close()
+
+	conn, err := websocket.Dial("ws" + strings.TrimPrefix(web.URL, "http") + protocol.DefaultWebSocketPath, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := protocol.WriteMessage(conn, &protocol.Hello{Version: protocol.Version, Name: "Red", Password: "pikachu"}); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := protocol.ReadMessage(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if welcome, ok := msg.(*protocol.Welcome); !ok || welcome.Name != "Red" {
+		t.Errorf("Expected a welcome for Red, got %+v", msg)
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"github.com/atemmel/pok/pkg/protocol"
+	"sort"
+)
+
+type tile struct {
+	x, y, z int
+}
+
+// mapState remembers what players have done to the objects on a single map.
+// The server never loads the maps themselves, so objects are only known by
+// the positions players report them at.
+type mapState struct {
+	smashed map[tile]bool
+	cut map[tile]bool
+	boulders map[tile]tile	// current position -> starting position
+}
+
+func newMapState() *mapState {
+	return &mapState{
+		make(map[tile]bool),
+		make(map[tile]bool),
+		make(map[tile]tile),
+	}
+}
+
+// apply records an event, returning false if it does not make sense given
+// what is already known, such as smashing the same rock twice
+func (m *mapState) apply(ev *protocol.WorldEvent) bool {
+	at := tile{ev.X, ev.Y, ev.Z}
+
+	switch ev.Event {
+		case protocol.RockSmashed:
+			if m.smashed[at] {
+				return false
+			}
+			m.smashed[at] = true
+		case protocol.TreeCut:
+			if m.cut[at] {
+				return false
+			}
+			m.cut[at] = true
+		case protocol.BoulderMoved:
+			dx, dy := ev.ToX - ev.X, ev.ToY - ev.Y
+			if dx * dx + dy * dy != 1 {
+				return false
+			}
+
+			to := tile{ev.ToX, ev.ToY, ev.Z}
+			if _, taken := m.boulders[to]; taken {
+				return false
+			}
+
+			origin, moved := m.boulders[at]
+			if !moved {
+				origin = at
+			}
+			delete(m.boulders, at)
+			if to != origin {
+				m.boulders[to] = origin
+			}
+		default:
+			return false
+	}
+	return true
+}
+
+// boulderMovedFrom reports whether the boulder that started at origin has
+// been moved somewhere else
+func (m *mapState) boulderMovedFrom(origin tile) bool {
+	for _, o := range m.boulders {
+		if o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// events describes the state of the map as a list of events which, applied
+// to a freshly loaded map, bring it up to date. Boulders are moved straight
+// from where they started to where they are now.
+func (m *mapState) events() []protocol.WorldEvent {
+	events := make([]protocol.WorldEvent, 0, len(m.smashed) + len(m.cut) + len(m.boulders))
+
+	for at := range m.smashed {
+		events = append(events, protocol.WorldEvent{Event: protocol.RockSmashed, X: at.x, Y: at.y, Z: at.z})
+	}
+
+	for at := range m.cut {
+		events = append(events, protocol.WorldEvent{Event: protocol.TreeCut, X: at.x, Y: at.y, Z: at.z})
+	}
+
+	for at, origin := range m.boulders {
+		events = append(events, protocol.WorldEvent{
+			Event: protocol.BoulderMoved,
+			X: origin.x,
+			Y: origin.y,
+			Z: origin.z,
+			ToX: at.x,
+			ToY: at.y,
+		})
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		a, b := &events[i], &events[j]
+		if a.Event != b.Event {
+			return a.Event < b.Event
+		}
+		if a.Z != b.Z {
+			return a.Z < b.Z
+		}
+		if a.Y != b.Y {
+			return a.Y < b.Y
+		}
+		return a.X < b.X
+	})
+
+	return events
+}
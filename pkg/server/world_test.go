@@ -0,0 +1,48 @@
+package server
+
+import (
+	"github.com/atemmel/pok/pkg/protocol"
+	"reflect"
+	"testing"
+)
+
+func TestMapState(t *testing.T) {
+	m := newMapState()
+
+	type applyTest struct {
+		In protocol.WorldEvent
+		Want bool
+	}
+
+	tests := []applyTest{
+		{protocol.WorldEvent{Event: protocol.RockSmashed, X: 1, Y: 1}, true},
+		{protocol.WorldEvent{Event: protocol.RockSmashed, X: 1, Y: 1}, false},
+		{protocol.WorldEvent{Event: protocol.TreeCut, X: 2, Y: 2, Z: 1}, true},
+		// Boulder pushed right twice, then back left once
+		{protocol.WorldEvent{Event: protocol.BoulderMoved, X: 5, Y: 5, ToX: 6, ToY: 5}, true},
+		{protocol.WorldEvent{Event: protocol.BoulderMoved, X: 6, Y: 5, ToX: 7, ToY: 5}, true},
+		{protocol.WorldEvent{Event: protocol.BoulderMoved, X: 7, Y: 5, ToX: 6, ToY: 5}, true},
+		// A boulder that moves back to where it started is forgotten
+		{protocol.WorldEvent{Event: protocol.BoulderMoved, X: 9, Y: 9, ToX: 9, ToY: 8}, true},
+		{protocol.WorldEvent{Event: protocol.BoulderMoved, X: 9, Y: 8, ToX: 9, ToY: 9}, true},
+		// Boulders do not jump
+		{protocol.WorldEvent{Event: protocol.BoulderMoved, X: 0, Y: 0, ToX: 2, ToY: 0}, false},
+		{protocol.WorldEvent{Event: 0}, false},
+	}
+
+	for _, test := range tests {
+		if output := m.apply(&test.In); output != test.Want {
+			t.Errorf("apply(%+v) gave %t, expected %t", test.In, output, test.Want)
+		}
+	}
+
+	want := []protocol.WorldEvent{
+		{Event: protocol.RockSmashed, X: 1, Y: 1},
+		{Event: protocol.TreeCut, X: 2, Y: 2, Z: 1},
+		{Event: protocol.BoulderMoved, X: 5, Y: 5, ToX: 6, ToY: 5},
+	}
+
+	if output := m.events(); !reflect.DeepEqual(output, want) {
+		t.Errorf("Output %+v not equal to %+v", output, want)
+	}
+}
@@ -0,0 +1,594 @@
+package transport
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Conditions describe how badly a Memory network behaves. Packets may be
+// lost or arrive out of order. Streams never lose or reorder data, but a
+// lost write is held back until it would have been retransmitted, along
+// with everything written after it.
+type Conditions struct {
+	Latency time.Duration	// one way
+	Jitter time.Duration	// at most this much is added to Latency, at random
+	Loss float64	// chance of losing a write or packet, from 0 to 1
+	Reorder float64	// chance of holding a packet back so that later ones overtake it
+}
+
+// On top of a round trip, how long it takes for a lost write on a stream to
+// be sent again
+const retransmitTimeout = 200 * time.Millisecond
+
+// Packets beyond this many are dropped until the reader catches up
+const maxQueuedPackets = 1024
+
+// How many dialed streams may wait for a listener to accept them
+const listenBacklog = 128
+
+// Ports handed out when asked for port 0
+const firstEphemeralPort = 49152
+
+var (
+	ErrClosed = errors.New("use of closed network connection")
+	ErrRefused = errors.New("connection refused")
+	ErrAddrInUse = errors.New("address already in use")
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string {
+	return "i/o timeout"
+}
+
+func (timeoutError) Timeout() bool {
+	return true
+}
+
+func (timeoutError) Temporary() bool {
+	return true
+}
+
+// Memory is an in-process network. Addresses are written like on the real
+// network, but only the port matters, as if everything ran on the same
+// machine. Listening on port 0 picks a free port. Random choices come from
+// a seeded source, so the same writes in the same order meet the same fate.
+type Memory struct {
+	conditions Conditions
+	rng *rand.Rand
+	listeners map[int]*memoryListener
+	packetConns map[int]*packetConn
+	nextPort int
+	mutex sync.Mutex	// guards everything above
+}
+
+func NewMemory(seed int64, conditions Conditions) *Memory {
+	return &Memory{
+		conditions,
+		rand.New(rand.NewSource(seed)),
+		make(map[int]*memoryListener),
+		make(map[int]*packetConn),
+		firstEphemeralPort,
+		sync.Mutex{},
+	}
+}
+
+// SetConditions changes how the network behaves from now on. Writes that
+// are already under way are not affected.
+func (m *Memory) SetConditions(conditions Conditions) {
+	m.mutex.Lock()
+	m.conditions = conditions
+	m.mutex.Unlock()
+}
+
+// roll decides how long a write or packet takes to arrive, and whether it
+// is lost or reordered
+func (m *Memory) roll() (time.Duration, bool, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	c := m.conditions
+	delay := c.Latency
+	if c.Jitter > 0 {
+		delay += time.Duration(m.rng.Int63n(int64(c.Jitter) + 1))
+	}
+	lost := m.rng.Float64() < c.Loss
+	reordered := m.rng.Float64() < c.Reorder
+	return delay, lost, reordered
+}
+
+func (m *Memory) latency() time.Duration {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.conditions.Latency + m.conditions.Jitter
+}
+
+// ephemeralPort hands out a port that nothing listens on.
+// Assumes that mutex is held.
+func (m *Memory) ephemeralPort() int {
+	for {
+		port := m.nextPort
+		m.nextPort++
+		_, listening := m.listeners[port]
+		_, bound := m.packetConns[port]
+		if !listening && !bound {
+			return port
+		}
+	}
+}
+
+type memoryAddr struct {
+	port int
+}
+
+func (a memoryAddr) Network() string {
+	return "memory"
+}
+
+func (a memoryAddr) String() string {
+	return "memory:" + strconv.Itoa(a.port)
+}
+
+func parsePort(addr string) (int, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(port)
+}
+
+func (m *Memory) Listen(addr string) (net.Listener, error) {
+	port, err := parsePort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if port == 0 {
+		port = m.ephemeralPort()
+	} else if _, taken := m.listeners[port]; taken {
+		return nil, ErrAddrInUse
+	}
+
+	l := &memoryListener{
+		network: m,
+		addr: memoryAddr{port},
+		accept: make(chan net.Conn, listenBacklog),
+		done: make(chan struct{}),
+	}
+	m.listeners[port] = l
+	return l, nil
+}
+
+func (m *Memory) Dial(addr string) (net.Conn, error) {
+	port, err := parsePort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mutex.Lock()
+	l, ok := m.listeners[port]
+	local := memoryAddr{m.ephemeralPort()}
+	m.mutex.Unlock()
+	if !ok {
+		return nil, ErrRefused
+	}
+
+	toServer, toClient := newPipe(), newPipe()
+	client := &streamConn{m, local, l.addr, toClient, toServer}
+	server := &streamConn{m, l.addr, local, toServer, toClient}
+
+	select {
+		case l.accept <- server:
+			return client, nil
+		case <-l.done:
+			return nil, ErrRefused
+	}
+}
+
+type memoryListener struct {
+	network *Memory
+	addr memoryAddr
+	accept chan net.Conn
+	done chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *memoryListener) Accept() (net.Conn, error) {
+	select {
+		case conn := <-l.accept:
+			return conn, nil
+		case <-l.done:
+			return nil, ErrClosed
+	}
+}
+
+func (l *memoryListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		l.network.mutex.Lock()
+		delete(l.network.listeners, l.addr.port)
+		l.network.mutex.Unlock()
+	})
+	return nil
+}
+
+func (l *memoryListener) Addr() net.Addr {
+	return l.addr
+}
+
+// segment is a single write on its way through a pipe
+type segment struct {
+	data []byte
+	at time.Time	// when it arrives
+	fin bool	// the writer closed its end
+}
+
+// pipe carries one direction of a stream
+type pipe struct {
+	queue []segment	// on their way, in order
+	last time.Time	// when the last segment in queue arrives
+	readable []byte	// arrived, but not yet read
+	eof bool	// the writer closed, and everything it wrote has arrived
+	writeClosed bool
+	readClosed bool
+	deadline time.Time	// for reads
+	changed chan struct{}	// closed whenever anything above changes
+	mutex sync.Mutex
+}
+
+func newPipe() *pipe {
+	return &pipe{changed: make(chan struct{})}
+}
+
+// Assumes that mutex is held
+func (p *pipe) signal() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// send queues a segment to arrive after delay, though never before the
+// segments already queued
+func (p *pipe) send(seg segment, delay time.Duration) {
+	p.mutex.Lock()
+	seg.at = time.Now().Add(delay)
+	if seg.at.Before(p.last) {
+		seg.at = p.last
+	}
+	p.last = seg.at
+	p.queue = append(p.queue, seg)
+	p.mutex.Unlock()
+
+	time.AfterFunc(time.Until(seg.at), p.arrive)
+}
+
+// arrive makes every segment that is due readable
+func (p *pipe) arrive() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	for len(p.queue) > 0 && !p.queue[0].at.After(now) {
+		seg := p.queue[0]
+		p.queue = p.queue[1:]
+		if seg.fin {
+			p.eof = true
+		} else if !p.readClosed {
+			p.readable = append(p.readable, seg.data...)
+		}
+	}
+	p.signal()
+}
+
+func (p *pipe) read(b []byte) (int, error) {
+	for {
+		p.mutex.Lock()
+		switch {
+			case p.readClosed:
+				p.mutex.Unlock()
+				return 0, ErrClosed
+			case len(p.readable) > 0:
+				n := copy(b, p.readable)
+				p.readable = p.readable[n:]
+				p.mutex.Unlock()
+				return n, nil
+			case p.eof:
+				p.mutex.Unlock()
+				return 0, io.EOF
+			case !p.deadline.IsZero() && !time.Now().Before(p.deadline):
+				p.mutex.Unlock()
+				return 0, timeoutError{}
+		}
+		changed, deadline := p.changed, p.deadline
+		p.mutex.Unlock()
+
+		wait(changed, deadline)
+	}
+}
+
+func (p *pipe) setDeadline(t time.Time) {
+	p.mutex.Lock()
+	p.deadline = t
+	p.signal()
+	p.mutex.Unlock()
+}
+
+// wait blocks until changed is closed or the deadline passes
+func wait(changed chan struct{}, deadline time.Time) {
+	if deadline.IsZero() {
+		<-changed
+		return
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+		case <-changed:
+		case <-timer.C:
+	}
+}
+
+type streamConn struct {
+	network *Memory
+	local, remote memoryAddr
+	in *pipe
+	out *pipe
+}
+
+func (c *streamConn) Read(b []byte) (int, error) {
+	return c.in.read(b)
+}
+
+func (c *streamConn) Write(b []byte) (int, error) {
+	c.out.mutex.Lock()
+	closed := c.out.writeClosed
+	c.out.mutex.Unlock()
+	if closed {
+		return 0, ErrClosed
+	}
+
+	delay, lost, _ := c.network.roll()
+	if lost {
+		delay += 2 * c.network.latency() + retransmitTimeout
+	}
+	c.out.send(segment{data: append([]byte(nil), b...)}, delay)
+	return len(b), nil
+}
+
+func (c *streamConn) Close() error {
+	c.out.mutex.Lock()
+	closed := c.out.writeClosed
+	c.out.writeClosed = true
+	c.out.mutex.Unlock()
+	if closed {
+		return ErrClosed
+	}
+
+	delay, _, _ := c.network.roll()
+	c.out.send(segment{fin: true}, delay)
+
+	c.in.mutex.Lock()
+	c.in.readClosed = true
+	c.in.readable = nil
+	c.in.signal()
+	c.in.mutex.Unlock()
+	return nil
+}
+
+func (c *streamConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *streamConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *streamConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *streamConn) SetReadDeadline(t time.Time) error {
+	c.in.setDeadline(t)
+	return nil
+}
+
+// Writes never block, so there is nothing for a write deadline to do
+func (c *streamConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+type packet struct {
+	data []byte
+	from net.Addr
+}
+
+type packetConn struct {
+	network *Memory
+	addr memoryAddr
+	inbox []packet
+	closed bool
+	deadline time.Time	// for reads
+	changed chan struct{}	// closed whenever anything above changes
+	mutex sync.Mutex
+}
+
+func (m *Memory) ListenPacket(addr string) (net.PacketConn, error) {
+	port, err := parsePort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if port == 0 {
+		port = m.ephemeralPort()
+	} else if _, taken := m.packetConns[port]; taken {
+		return nil, ErrAddrInUse
+	}
+
+	conn := &packetConn{network: m, addr: memoryAddr{port}, changed: make(chan struct{})}
+	m.packetConns[port] = conn
+	return conn, nil
+}
+
+// DialPacket binds a free port, and only talks to addr from it
+func (m *Memory) DialPacket(addr string) (net.Conn, error) {
+	port, err := parsePort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := m.ListenPacket(":0")
+	if err != nil {
+		return nil, err
+	}
+	return &connectedPacketConn{conn.(*packetConn), memoryAddr{port}}, nil
+}
+
+// deliver puts a packet in the inbox of whoever is bound to port, if
+// anyone is
+func (m *Memory) deliver(port int, p packet) {
+	m.mutex.Lock()
+	conn, ok := m.packetConns[port]
+	m.mutex.Unlock()
+	if !ok {
+		return
+	}
+
+	conn.mutex.Lock()
+	if !conn.closed && len(conn.inbox) < maxQueuedPackets {
+		conn.inbox = append(conn.inbox, p)
+		conn.signal()
+	}
+	conn.mutex.Unlock()
+}
+
+// Assumes that mutex is held
+func (c *packetConn) signal() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		c.mutex.Lock()
+		switch {
+			case c.closed:
+				c.mutex.Unlock()
+				return 0, nil, ErrClosed
+			case len(c.inbox) > 0:
+				p := c.inbox[0]
+				c.inbox = c.inbox[1:]
+				c.mutex.Unlock()
+				// Like udp, whatever does not fit is lost
+				return copy(b, p.data), p.from, nil
+			case !c.deadline.IsZero() && !time.Now().Before(c.deadline):
+				c.mutex.Unlock()
+				return 0, nil, timeoutError{}
+		}
+		changed, deadline := c.changed, c.deadline
+		c.mutex.Unlock()
+
+		wait(changed, deadline)
+	}
+}
+
+func (c *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.mutex.Lock()
+	closed := c.closed
+	c.mutex.Unlock()
+	if closed {
+		return 0, ErrClosed
+	}
+
+	port, err := parsePort(addr.String())
+	if err != nil {
+		return 0, err
+	}
+
+	delay, lost, reordered := c.network.roll()
+	if lost {
+		return len(b), nil
+	}
+	if reordered {
+		delay += c.network.latency() + time.Millisecond
+	}
+
+	p := packet{append([]byte(nil), b...), c.addr}
+	time.AfterFunc(delay, func() {
+		c.network.deliver(port, p)
+	})
+	return len(b), nil
+}
+
+func (c *packetConn) Close() error {
+	c.mutex.Lock()
+	closed := c.closed
+	c.closed = true
+	c.inbox = nil
+	c.signal()
+	c.mutex.Unlock()
+	if closed {
+		return ErrClosed
+	}
+
+	c.network.mutex.Lock()
+	delete(c.network.packetConns, c.addr.port)
+	c.network.mutex.Unlock()
+	return nil
+}
+
+func (c *packetConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+func (c *packetConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *packetConn) SetReadDeadline(t time.Time) error {
+	c.mutex.Lock()
+	c.deadline = t
+	c.signal()
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *packetConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// connectedPacketConn is a packetConn that only talks to a single address,
+// like a dialed udp socket
+type connectedPacketConn struct {
+	*packetConn
+	remote memoryAddr
+}
+
+func (c *connectedPacketConn) Read(b []byte) (int, error) {
+	for {
+		n, from, err := c.ReadFrom(b)
+		if err != nil {
+			return 0, err
+		}
+		if from.String() == c.remote.String() {
+			return n, nil
+		}
+	}
+}
+
+func (c *connectedPacketConn) Write(b []byte) (int, error) {
+	return c.WriteTo(b, c.remote)
+}
+
+func (c *connectedPacketConn) RemoteAddr() net.Addr {
+	return c.remote
+}
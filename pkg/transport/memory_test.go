@@ -0,0 +1,155 @@
+package transport
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStreamKeepsOrder(t *testing.T) {
+	m := NewMemory(1, Conditions{Latency: 5 * time.Millisecond, Jitter: 10 * time.Millisecond, Loss: 0.2})
+	l, err := m.Listen(":7000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := m.Dial("localhost:7000")
+		if err != nil {
+			return
+		}
+		for i := 0; i < 100; i++ {
+			conn.Write([]byte{byte(i)})
+		}
+		conn.Close()
+	}()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	output, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := make([]byte, 100)
+	for i := range want {
+		want[i] = byte(i)
+	}
+	if !bytes.Equal(output, want) {
+		t.Errorf("Output %v not equal to %v", output, want)
+	}
+}
+
+func TestStreamLatency(t *testing.T) {
+	m := NewMemory(1, Conditions{Latency: 50 * time.Millisecond})
+	l, _ := m.Listen(":7000")
+	defer l.Close()
+
+	go func() {
+		conn, _ := l.Accept()
+		io.Copy(conn, conn)
+	}()
+
+	conn, err := m.Dial(":7000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	conn.Write([]byte("ping"))
+	if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if rtt := time.Since(start); rtt < 100 * time.Millisecond {
+		t.Errorf("Round trip took %v, expected at least %v", rtt, 100 * time.Millisecond)
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	m := NewMemory(1, Conditions{})
+	l, _ := m.Listen(":7000")
+	defer l.Close()
+
+	conn, err := m.Dial(":7000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, err = conn.Read(make([]byte, 1))
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+}
+
+func TestDialRefused(t *testing.T) {
+	m := NewMemory(1, Conditions{})
+	if _, err := m.Dial(":7000"); err != ErrRefused {
+		t.Errorf("Expected %v, got %v", ErrRefused, err)
+	}
+}
+
+func TestPackets(t *testing.T) {
+	m := NewMemory(1, Conditions{Jitter: 5 * time.Millisecond, Loss: 0.25, Reorder: 0.25})
+	server, err := m.ListenPacket(":7000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := m.DialPacket("localhost:7000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	const sent = 200
+	for i := 0; i < sent; i++ {
+		client.Write([]byte{byte(i)})
+	}
+
+	received := 0
+	reordered := false
+	last := -1
+	server.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	buf := make([]byte, 16)
+	for {
+		n, from, err := server.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		if n != 1 || from.String() != client.LocalAddr().String() {
+			t.Fatalf("Unexpected packet %v from %v", buf[:n], from)
+		}
+		if int(buf[0]) < last {
+			reordered = true
+		}
+		last = int(buf[0])
+		received++
+	}
+
+	if received == 0 || received == sent {
+		t.Errorf("Expected some but not all of %d packets to arrive, %d did", sent, received)
+	}
+	if !reordered {
+		t.Errorf("Expected some packets to arrive out of order")
+	}
+
+	// Answers find their way back to the dialed conn
+	server.WriteTo([]byte("pong"), client.LocalAddr())
+	m.SetConditions(Conditions{})
+	server.WriteTo([]byte("pong"), client.LocalAddr())
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if n, err := client.Read(buf); err != nil || string(buf[:n]) != "pong" {
+		t.Errorf("Expected pong, got %q, %v", buf[:n], err)
+	}
+}
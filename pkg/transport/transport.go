@@ -0,0 +1,36 @@
+package transport
+
+import (
+	"net"
+)
+
+// Network opens the connections that servers and clients talk over. Streams
+// carry the protocol, and packets carry the optional udp channel. System is
+// the real network, and a Memory network can be used in tests.
+type Network interface {
+	Listen(addr string) (net.Listener, error)
+	Dial(addr string) (net.Conn, error)
+	ListenPacket(addr string) (net.PacketConn, error)
+	DialPacket(addr string) (net.Conn, error)
+}
+
+type system struct{}
+
+// System is the real network, using tcp for streams and udp for packets
+var System Network = system{}
+
+func (system) Listen(addr string) (net.Listener, error) {
+	return net.Listen("tcp", addr)
+}
+
+func (system) Dial(addr string) (net.Conn, error) {
+	return net.Dial("tcp", addr)
+}
+
+func (system) ListenPacket(addr string) (net.PacketConn, error) {
+	return net.ListenPacket("udp", addr)
+}
+
+func (system) DialPacket(addr string) (net.Conn, error) {
+	return net.Dial("udp", addr)
+}
@@ -0,0 +1,292 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Appended to the key of the client to prove that the server understood
+// the handshake, as given by RFC 6455
+const acceptGuid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// MaxFrameSize is the largest frame payload that will be read. Larger
+// writes are split into several frames.
+const MaxFrameSize = 1 << 16
+
+const (
+	continuationFrame = 0x0
+	textFrame = 0x1
+	binaryFrame = 0x2
+	closeFrame = 0x8
+	pingFrame = 0x9
+	pongFrame = 0xa
+)
+
+var (
+	ErrNotWebSocket = errors.New("websocket: not a websocket handshake")
+	ErrFrameTooLarge = errors.New("websocket: frame too large")
+	ErrProtocol = errors.New("websocket: protocol violation")
+	ErrClosed = errors.New("websocket: connection closed")
+)
+
+// Conn is a websocket connection which can be used wherever a net.Conn is
+// expected. Every Write is sent as a binary message, and Read returns the
+// payloads of incoming messages as a single stream of bytes. Control frames
+// are answered while reading.
+type Conn struct {
+	conn net.Conn
+	reader *bufio.Reader
+	client bool	// clients mask what they send, servers must not
+	pending []byte	// read but not yet returned by Read
+	closeSent bool	// guarded by writeMutex
+	writeMutex sync.Mutex
+}
+
+func acceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + acceptGuid))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// headerContains reports whether a comma separated header holds a token
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Upgrade takes over an HTTP request asking for a websocket. If the request
+// is not a websocket handshake, an error is sent in reply.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet || !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "Expected a websocket handshake", http.StatusBadRequest)
+		return nil, ErrNotWebSocket
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, ErrNotWebSocket
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "Missing websocket key", http.StatusBadRequest)
+		return nil, ErrNotWebSocket
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Websockets are not supported", http.StatusInternalServerError)
+		return nil, errors.New("websocket: connection cannot be hijacked")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	// The http server may have left deadlines behind
+	conn.SetDeadline(time.Time{})
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &Conn{conn: conn, reader: rw.Reader}, nil
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		if err := c.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// readFrame reads a single frame, answering it right away if it is a
+// control frame
+func (c *Conn) readFrame() error {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return err
+	}
+
+	opcode := header[0] & 0x0f
+	masked := header[1] & 0x80 != 0
+	if masked == c.client {
+		return ErrProtocol
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+		case 126:
+			var extended [2]byte
+			if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+				return err
+			}
+			length = uint64(binary.BigEndian.Uint16(extended[:]))
+		case 127:
+			var extended [8]byte
+			if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+				return err
+			}
+			length = binary.BigEndian.Uint64(extended[:])
+	}
+
+	if length > MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+	if opcode >= closeFrame && (length > 125 || header[0] & 0x80 == 0) {
+		return ErrProtocol
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return err
+		}
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i % 4]
+		}
+	}
+
+	switch opcode {
+		case continuationFrame, textFrame, binaryFrame:
+			c.pending = payload
+		case pingFrame:
+			return c.writeFrame(pongFrame, payload)
+		case pongFrame:
+		case closeFrame:
+			c.writeFrame(closeFrame, nil)
+			return io.EOF
+		default:
+			return ErrProtocol
+	}
+	return nil
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	written := 0
+	for {
+		chunk := p[written:]
+		if len(chunk) > MaxFrameSize {
+			chunk = chunk[:MaxFrameSize]
+		}
+
+		if err := c.writeFrame(binaryFrame, chunk); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+
+		if written == len(p) {
+			return written, nil
+		}
+	}
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if c.closeSent {
+		return ErrClosed
+	}
+	if opcode == closeFrame {
+		c.closeSent = true
+	}
+
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+
+	frame := make([]byte, 0, 14 + len(payload))
+	frame = append(frame, 0x80 | opcode)
+	switch {
+		case len(payload) < 126:
+			frame = append(frame, maskBit | byte(len(payload)))
+		case len(payload) <= 0xffff:
+			frame = append(frame, maskBit | 126, 0, 0)
+			binary.BigEndian.PutUint16(frame[len(frame) - 2:], uint16(len(payload)))
+		default:
+			frame = append(frame, maskBit | 127, 0, 0, 0, 0, 0, 0, 0, 0)
+			binary.BigEndian.PutUint64(frame[len(frame) - 8:], uint64(len(payload)))
+	}
+
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		for i := start; i < len(frame); i++ {
+			frame[i] ^= mask[(i - start) % 4]
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// Close tells the other end that the connection is going away, then closes
+// the underlying connection without waiting for an answer
+func (c *Conn) Close() error {
+	c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	c.writeFrame(closeFrame, []byte{0x03, 0xe8})	// 1000, normal closure
+	return c.conn.Close()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,138 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func echoServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}))
+}
+
+func wsUrl(server *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/echo"
+}
+
+func TestAcceptKey(t *testing.T) {
+	// The example from RFC 6455
+	if output := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="); output != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Output %q not equal to %q", output, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+	}
+}
+
+func TestEcho(t *testing.T) {
+	server := echoServer()
+	defer server.Close()
+
+	conn, err := Dial(wsUrl(server), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Covers every way of encoding the payload length, and more than fits
+	// in a single frame
+	tests := [][]byte{
+		[]byte("hello"),
+		bytes.Repeat([]byte{1}, 125),
+		bytes.Repeat([]byte{2}, 126),
+		bytes.Repeat([]byte{3}, 0xffff + 1),
+		bytes.Repeat([]byte{4}, 3 * MaxFrameSize + 7),
+	}
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	for _, test := range tests {
+		if _, err := conn.Write(test); err != nil {
+			t.Fatal(err)
+		}
+
+		output := make([]byte, len(test))
+		if _, err := io.ReadFull(conn, output); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(output, test) {
+			t.Errorf("Echo of %d bytes did not match", len(test))
+		}
+	}
+}
+
+func TestPing(t *testing.T) {
+	server := echoServer()
+	defer server.Close()
+
+	conn, err := Dial(wsUrl(server), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The server answers the ping before echoing what comes after it, and
+	// the pong is swallowed while reading
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.writeFrame(pingFrame, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("after")); err != nil {
+		t.Fatal(err)
+	}
+
+	output := make([]byte, 5)
+	if _, err := io.ReadFull(conn, output); err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != "after" {
+		t.Errorf("Output %q not equal to %q", output, "after")
+	}
+}
+
+func TestClose(t *testing.T) {
+	server := echoServer()
+	defer server.Close()
+
+	conn, err := Dial(wsUrl(server), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.writeFrame(closeFrame, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server echoes the close and hangs up
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected EOF after closing, got %v", err)
+	}
+}
+
+func TestRejectPlainRequests(t *testing.T) {
+	server := echoServer()
+	defer server.Close()
+
+	response, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Status %d not equal to %d", response.StatusCode, http.StatusBadRequest)
+	}
+
+	if _, err := Dial("http" + strings.TrimPrefix(server.URL, "http"), time.Second); err == nil {
+		t.Errorf("Dialing an http url should fail")
+	}
+}